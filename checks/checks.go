@@ -20,17 +20,31 @@ type HealthChecks struct {
 	currentStatus     *HealthCheckResults
 	statusCounts      [3]uint
 	checkResults      []*HealthCheckResult
+	criticalSince     []time.Time
+	unhealthyNotified []bool
+	effectiveStatus   []HealthStatus
+	histories         []*resultHistory
+	historySize       uint
+	flapThreshold     uint
+	flapWindow        time.Duration
+	onUpdate          func(c *HealthChecks)
+	minUpdateInterval time.Duration
 	statusChan        chan *HealthCheckResult
 	quitChan          chan bool
+	mutex             sync.Mutex
 	wait              sync.WaitGroup
 	waitReporter      sync.WaitGroup
 	debug             log.Logger
 }
 
 type HealthCheck struct {
-	name     string
-	interval time.Duration
-	test     HealthIndicator
+	name               string
+	interval           time.Duration
+	startPeriod        time.Duration
+	retries            uint
+	test               HealthIndicator
+	unhealthyThreshold time.Duration
+	onUnhealthy        UnhealthyHandler
 }
 
 type HealthStatus byte
@@ -65,25 +79,72 @@ type HealthCheckResult struct {
 	name   string
 	status HealthStatus
 	output string
+	// true if the result must not count as unhealthy (start period or termination)
+	transient bool
+	time      time.Time
+	duration  time.Duration
 }
 
 func NewHealthCheckResult(status HealthStatus, output string) *HealthCheckResult {
-	return &HealthCheckResult{0, "", status, output}
+	return &HealthCheckResult{0, "", status, output, false, time.Time{}, 0}
 }
 
 type HealthIndicator func() *HealthCheckResult
 
 type HealthReporter func(r *HealthCheckResults) error
 
+// Called when a check stayed critical longer than its unhealthy threshold
+type UnhealthyHandler func(check string, unhealthyFor time.Duration, output string)
+
 func NewHealthChecks(debug log.Logger, reporter HealthReporter, minReportInterval time.Duration, checks ...*HealthCheck) *HealthChecks {
 	c := &HealthChecks{}
 	c.checks = checks
 	c.reporter = reporter
 	c.minReportInterval = minReportInterval
+	c.historySize = DEFAULT_HISTORY_SIZE
 	c.debug = debug
 	return c
 }
 
+// Sets the number of results kept per check
+func (c *HealthChecks) SetHistorySize(size uint) {
+	c.historySize = size
+}
+
+// Enables flap detection: A check that changed its status more than
+// threshold times within the window contributes warning instead of passing
+// to the aggregated status. A critical check remains critical.
+func (c *HealthChecks) SetFlapDetection(threshold uint, window time.Duration) {
+	c.flapThreshold = threshold
+	c.flapWindow = window
+}
+
+// Registers a function that is called after each check result.
+// Unless a status changed it is called at most once per minInterval
+// with the latest results.
+func (c *HealthChecks) SetUpdateListener(l func(c *HealthChecks), minInterval time.Duration) {
+	c.onUpdate = l
+	c.minUpdateInterval = minInterval
+}
+
+// Returns the aggregated status
+func (c *HealthChecks) Status() *HealthCheckResults {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.currentStatus
+}
+
+// Returns every check's current state and result history
+func (c *HealthChecks) States() []*HealthCheckState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	r := make([]*HealthCheckState, len(c.checkResults))
+	for i, res := range c.checkResults {
+		r[i] = &HealthCheckState{res.name, res.status, c.isFlapping(i), c.histories[i].list()}
+	}
+	return r
+}
+
 func (c *HealthChecks) Start() {
 	if c.statusChan != nil {
 		panic("healthchecks already started")
@@ -97,7 +158,13 @@ func (c *HealthChecks) Start() {
 	}
 	c.statusCounts = [3]uint{0, 0, uint(checkCount)}
 	c.checkResults = make([]*HealthCheckResult, checkCount)
+	c.criticalSince = make([]time.Time, checkCount)
+	c.unhealthyNotified = make([]bool, checkCount)
+	c.effectiveStatus = make([]HealthStatus, checkCount)
+	c.histories = make([]*resultHistory, checkCount)
 	for i := 0; i < checkCount; i++ {
+		c.effectiveStatus[i] = STATUS_CRITICAL
+		c.histories[i] = newResultHistory(c.historySize)
 		c.checkResults[i] = &HealthCheckResult{}
 		c.checkResults[i].name = c.checks[i].name
 		c.checkResults[i].status = STATUS_CRITICAL
@@ -119,62 +186,107 @@ func (c *HealthChecks) Start() {
 
 func (c *HealthChecks) Stop() {
 	c.debug.Println("Stopping health checks...")
+	c.mutex.Lock()
 	reporter := c.reporter
 	c.reporter = func(r *HealthCheckResults) error { return nil }
+	c.mutex.Unlock()
 	close(c.quitChan)   // Stop check goroutines
 	c.wait.Wait()       // Wait for check goroutines
 	close(c.statusChan) // Stop reporter goroutine
 	c.statusChan = nil
 	c.quitChan = nil
 	c.waitReporter.Wait() // Wait for reporter goroutine to terminate
+	c.mutex.Lock()
 	c.currentStatus.status = STATUS_CRITICAL
 	c.reporter = reporter
+	c.mutex.Unlock()
 	c.doReportStatus()
 }
 
 func (c *HealthChecks) report(status <-chan *HealthCheckResult, quit <-chan bool) {
 	defer c.waitReporter.Done()
-	ticker := time.NewTicker(c.minReportInterval)
+	var tick <-chan time.Time
+	stopTicker := func() {}
 	resetTicker := func() {}
 	if c.minReportInterval > 0 {
+		ticker := time.NewTicker(c.minReportInterval)
+		tick = ticker.C
+		stopTicker = func() { ticker.Stop() }
 		resetTicker = func() {
 			ticker.Stop()
 			ticker = time.NewTicker(c.minReportInterval)
+			tick = ticker.C
 		}
-	} else {
-		ticker.Stop()
+	}
+	var lastUpdate time.Time
+	var pendingUpdate <-chan time.Time
+	notifyUpdate := func() {
+		pendingUpdate = nil
+		lastUpdate = time.Now()
+		c.onUpdate(c)
 	}
 	for {
 		select {
 		case s, ok := <-status:
 			if !ok {
-				ticker.Stop()
+				stopTicker()
+				if pendingUpdate != nil {
+					notifyUpdate()
+				}
 				return
 			}
 			c.debug.Printf("Check %q %s", s.name, s.status)
-			if c.updateStatus(s) {
+			changed, checkChanged := c.updateStatus(s)
+			if c.onUpdate != nil {
+				wait := c.minUpdateInterval - time.Since(lastUpdate)
+				if changed || checkChanged || wait <= 0 {
+					notifyUpdate()
+				} else if pendingUpdate == nil {
+					// Throttle updates but publish the latest result eventually
+					pendingUpdate = time.After(wait)
+				}
+			}
+			if changed {
 				resetTicker()
 				c.doReportStatus()
 			}
-		case <-ticker.C:
+		case <-pendingUpdate:
+			notifyUpdate()
+		case <-tick:
 			c.doReportStatus()
 		}
 	}
 }
 
 func (c *HealthChecks) doReportStatus() {
-	err := c.reporter(c.currentStatus)
+	c.mutex.Lock()
+	reporter, status := c.reporter, c.currentStatus
+	c.mutex.Unlock()
+	err := reporter(status)
 	if err != nil {
 		os.Stderr.WriteString(fmt.Sprintf("Error: health reporter: %s\n", err))
 	}
 }
 
-func (c *HealthChecks) updateStatus(r *HealthCheckResult) (changed bool) {
-	last := c.checkResults[r.index]
+// Returns whether the aggregated status and whether the check's status changed
+func (c *HealthChecks) updateStatus(r *HealthCheckResult) (changed, checkChanged bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	checkChanged = c.checkResults[r.index].status != r.status
 	c.checkResults[r.index] = r
-	if last.status != r.status {
-		c.statusCounts[last.status]--
-		c.statusCounts[r.status]++
+	c.histories[r.index].add(&HealthCheckRecord{r.time, r.duration, r.status, r.output})
+	c.detectUnhealthy(r)
+	last := c.effectiveStatus[r.index]
+	effective := r.status
+	if effective == STATUS_PASSING && c.isFlapping(int(r.index)) {
+		// Hold flapping check at warning
+		effective = STATUS_WARNING
+	}
+	c.effectiveStatus[r.index] = effective
+	if last != effective {
+		checkChanged = true
+		c.statusCounts[last]--
+		c.statusCounts[effective]++
 	}
 	status := c.currentStatus.status
 	for i := byte(2); i >= 0; i-- {
@@ -191,40 +303,87 @@ func (c *HealthChecks) updateStatus(r *HealthCheckResult) (changed bool) {
 	return
 }
 
+func (c *HealthChecks) detectUnhealthy(r *HealthCheckResult) {
+	check := c.checks[r.index]
+	if r.status != STATUS_CRITICAL || r.transient {
+		if r.status != STATUS_CRITICAL {
+			c.criticalSince[r.index] = time.Time{}
+			c.unhealthyNotified[r.index] = false
+		}
+		return
+	}
+	now := time.Now()
+	if c.criticalSince[r.index].IsZero() {
+		c.criticalSince[r.index] = now
+	}
+	unhealthyFor := now.Sub(c.criticalSince[r.index])
+	if check.onUnhealthy != nil && !c.unhealthyNotified[r.index] && unhealthyFor >= check.unhealthyThreshold {
+		c.unhealthyNotified[r.index] = true
+		// Handler is called asynchronously since it may stop the checks
+		go check.onUnhealthy(check.name, unhealthyFor, r.output)
+	}
+}
+
+func (c *HealthChecks) isFlapping(i int) bool {
+	if c.flapThreshold == 0 {
+		return false
+	}
+	return c.histories[i].transitions(time.Now().Add(-c.flapWindow)) > c.flapThreshold
+}
+
 func (c *HealthChecks) combinedOutput() string {
 	if len(c.checkResults) == 1 {
+		if c.isFlapping(0) {
+			return "flapping - " + c.checkResults[0].output
+		}
 		return c.checkResults[0].output
 	} else {
 		msg := make([]string, len(c.checkResults))
 		for i, r := range c.checkResults {
+			status := r.status.String()
+			if c.isFlapping(i) {
+				status += " (flapping)"
+			}
 			if len(r.output) > 0 {
-				msg[i] = fmt.Sprintf("%s %s - %s", r.name, r.status, strings.Replace(r.output, "\n", "\n  ", -1))
+				msg[i] = fmt.Sprintf("%s %s - %s", r.name, status, strings.Replace(r.output, "\n", "\n  ", -1))
 			} else {
-				msg[i] = fmt.Sprintf("%s %s", r.name, r.status)
+				msg[i] = fmt.Sprintf("%s %s", r.name, status)
 			}
 		}
 		return strings.Join(msg, "\n")
 	}
 }
 
-func NewHealthCheck(name string, interval time.Duration, indicator HealthIndicator) *HealthCheck {
-	return &HealthCheck{name, interval, indicator}
+// Creates a health check with docker's semantics:
+// Failures during the startPeriod are not counted.
+// The check becomes critical after retries consecutive failures
+// and reports warning in between.
+func NewHealthCheck(name string, interval, startPeriod time.Duration, retries uint, indicator HealthIndicator) *HealthCheck {
+	if retries == 0 {
+		retries = 1
+	}
+	return &HealthCheck{name, interval, startPeriod, retries, indicator, 0, nil}
+}
+
+// Registers a handler that is called once the check stayed critical
+// for longer than the threshold. It is called again only after the check recovered.
+func (c *HealthCheck) SetUnhealthyHandler(threshold time.Duration, handler UnhealthyHandler) {
+	c.unhealthyThreshold = threshold
+	c.onUnhealthy = handler
 }
 
 func (c *HealthCheck) run(index uint, status chan<- *HealthCheckResult, quit <-chan bool, wait *sync.WaitGroup, debug log.Logger) {
 	defer wait.Done()
-	defer func() { status <- &HealthCheckResult{index, c.name, STATUS_CRITICAL, "check terminated"} }()
+	defer func() {
+		status <- &HealthCheckResult{index, c.name, STATUS_CRITICAL, "check terminated", true, time.Now(), 0}
+	}()
+	state := &healthCheckState{started: time.Now()}
+	// Probe faster during start period to detect a started app early
 	initInterval := time.Duration(math.Min(float64(time.Second), float64(c.interval)))
-	for i := 0; i < 10; i++ {
+	for !state.passed && time.Since(state.started) < c.startPeriod {
 		select {
 		case <-time.After(initInterval):
-			r := c.test()
-			r.index = index
-			r.name = c.name
-			status <- r
-			if r.status != STATUS_CRITICAL {
-				i = 30
-			}
+			status <- c.probe(index, state)
 		case <-quit:
 			return
 		}
@@ -233,10 +392,7 @@ func (c *HealthCheck) run(index uint, status chan<- *HealthCheckResult, quit <-c
 	for {
 		select {
 		case <-ticker.C:
-			r := c.test()
-			r.index = index
-			r.name = c.name
-			status <- r
+			status <- c.probe(index, state)
 		case <-quit:
 			ticker.Stop()
 			return
@@ -244,6 +400,43 @@ func (c *HealthCheck) run(index uint, status chan<- *HealthCheckResult, quit <-c
 	}
 }
 
+func (c *HealthCheck) probe(index uint, state *healthCheckState) *HealthCheckResult {
+	start := time.Now()
+	r := c.test()
+	r.time = start
+	r.duration = time.Since(start)
+	r.index = index
+	r.name = c.name
+	state.apply(r, c.startPeriod, c.retries)
+	return r
+}
+
+// Tracks a single check's consecutive failures
+type healthCheckState struct {
+	started  time.Time
+	passed   bool
+	failures uint
+}
+
+func (s *healthCheckState) apply(r *HealthCheckResult, startPeriod time.Duration, retries uint) {
+	if r.status != STATUS_CRITICAL {
+		s.passed = true
+		s.failures = 0
+		return
+	}
+	if !s.passed && time.Since(s.started) < startPeriod {
+		// Failures within the start period don't count
+		r.output = "starting - " + r.output
+		r.transient = true
+		return
+	}
+	s.failures++
+	if s.failures < retries {
+		r.status = STATUS_WARNING
+		r.output = fmt.Sprintf("failed %d/%d - %s", s.failures, retries, r.output)
+	}
+}
+
 func NewCommandBasedHealthIndicator(debug log.Logger, timeout time.Duration, args ...string) HealthIndicator {
 	c := args[0]
 	a := args[1:]
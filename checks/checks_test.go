@@ -3,6 +3,10 @@ package checks
 import (
 	"fmt"
 	"github.com/mgoltzsche/rkt-compose/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
 	"strings"
 	"testing"
 	"time"
@@ -133,7 +137,7 @@ func TestHealthChecksMinInterval(t *testing.T) {
 
 func createCheck(status HealthStatus, output string) *HealthCheck {
 	checkCount++
-	return NewHealthCheck(fmt.Sprintf("ck-%d", checkCount), duration("5ms"), func() *HealthCheckResult { return NewHealthCheckResult(status, output) })
+	return NewHealthCheck(fmt.Sprintf("ck-%d", checkCount), duration("5ms"), 0, 1, func() *HealthCheckResult { return NewHealthCheckResult(status, output) })
 }
 
 func mockHealthReporter(r *HealthCheckResults) error {
@@ -152,3 +156,227 @@ func duration(d string) time.Duration {
 	}
 	return r
 }
+
+func TestHealthCheckRetries(t *testing.T) {
+	state := &healthCheckState{started: time.Now()}
+	expected := []struct {
+		probe    HealthStatus
+		reported HealthStatus
+	}{
+		{STATUS_CRITICAL, STATUS_WARNING}, // never passed
+		{STATUS_CRITICAL, STATUS_WARNING},
+		{STATUS_CRITICAL, STATUS_CRITICAL},
+		{STATUS_PASSING, STATUS_PASSING},
+		{STATUS_CRITICAL, STATUS_WARNING},
+		{STATUS_CRITICAL, STATUS_WARNING},
+		{STATUS_CRITICAL, STATUS_CRITICAL},
+		{STATUS_CRITICAL, STATUS_CRITICAL},
+		{STATUS_PASSING, STATUS_PASSING},
+		{STATUS_CRITICAL, STATUS_WARNING},
+	}
+	for i, e := range expected {
+		r := NewHealthCheckResult(e.probe, "out")
+		state.apply(r, 0, 3)
+		if r.status != e.reported {
+			t.Errorf("Probe %d: expected status %s but was %s", i, e.reported, r.status)
+		}
+	}
+}
+
+func TestHealthCheckStartPeriod(t *testing.T) {
+	state := &healthCheckState{started: time.Now()}
+	for i := 0; i < 5; i++ {
+		r := NewHealthCheckResult(STATUS_CRITICAL, "out")
+		state.apply(r, duration("1h"), 1)
+		if state.failures != 0 {
+			t.Errorf("Failure within start period should not be counted")
+			return
+		}
+		if r.status != STATUS_CRITICAL || strings.Index(r.output, "starting") != 0 {
+			t.Errorf("Unexpected result within start period: %s %q", r.status, r.output)
+			return
+		}
+	}
+	state.started = time.Now().Add(-duration("2h"))
+	r := NewHealthCheckResult(STATUS_CRITICAL, "out")
+	state.apply(r, duration("1h"), 1)
+	if state.failures != 1 || r.status != STATUS_CRITICAL {
+		t.Errorf("Failure after start period should be counted")
+	}
+}
+
+func TestHealthChecksUnhealthyHandler(t *testing.T) {
+	reportCount = 0
+	reported = nil
+	notified := make(chan string, 10)
+	ck := createCheck(STATUS_CRITICAL, "failed")
+	ck.SetUnhealthyHandler(duration("10ms"), func(check string, unhealthyFor time.Duration, output string) {
+		if unhealthyFor < duration("10ms") {
+			t.Errorf("Handler called before threshold exceeded: %s", unhealthyFor)
+		}
+		notified <- check
+	})
+	testee := NewHealthChecks(log.NewNopLogger(), mockHealthReporter, 0, ck)
+	testee.Start()
+	<-time.After(duration("60ms"))
+	testee.Stop()
+	if len(notified) != 1 {
+		t.Errorf("Unhealthy handler should be called once but was called %d times", len(notified))
+	}
+}
+
+func TestTcpHealthIndicator(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Cannot listen: %s", err)
+		return
+	}
+	addr := l.Addr().String()
+	testee := NewTcpHealthIndicator(addr, duration("1s"))
+	if r := testee(); r.status != STATUS_PASSING {
+		t.Errorf("Expected passing status but was %s: %s", r.status, r.output)
+	}
+	l.Close()
+	if r := testee(); r.status != STATUS_CRITICAL {
+		t.Errorf("Expected critical status for closed port but was %s", r.status)
+	}
+}
+
+func TestGrpcHealthIndicator(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Cannot listen: %s", err)
+	}
+	srv := grpc.NewServer()
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthSrv.SetServingStatus("db", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(srv, healthSrv)
+	go srv.Serve(l)
+	addr := l.Addr().String()
+	if r := NewGrpcHealthIndicator(addr, "", duration("1s"))(); r.status != STATUS_PASSING {
+		t.Errorf("Expected passing status but was %s: %s", r.status, r.output)
+	}
+	if r := NewGrpcHealthIndicator(addr, "db", duration("1s"))(); r.status != STATUS_CRITICAL || !strings.Contains(r.output, "NOT_SERVING") {
+		t.Errorf("Expected critical status for NOT_SERVING service but was %s: %s", r.status, r.output)
+	}
+	if r := NewGrpcHealthIndicator(addr, "unknown", duration("1s"))(); r.status != STATUS_CRITICAL {
+		t.Errorf("Expected critical status for unknown service but was %s", r.status)
+	}
+	srv.Stop()
+	if r := NewGrpcHealthIndicator(addr, "", duration("1s"))(); r.status != STATUS_CRITICAL {
+		t.Errorf("Expected critical status for stopped server but was %s", r.status)
+	}
+}
+
+func TestResultHistory(t *testing.T) {
+	testee := newResultHistory(3)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		testee.add(&HealthCheckRecord{now.Add(time.Duration(i) * time.Second), 0, HealthStatus(i % 2), fmt.Sprintf("r%d", i)})
+	}
+	l := testee.list()
+	if len(l) != 3 {
+		t.Errorf("Expected 3 records but was %d", len(l))
+		return
+	}
+	for i, r := range l {
+		if expected := fmt.Sprintf("r%d", i+2); r.Output != expected {
+			t.Errorf("Expected record %d to be %q but was %q", i, expected, r.Output)
+		}
+	}
+	if n := testee.transitions(now); n != 2 {
+		t.Errorf("Expected 2 transitions but was %d", n)
+	}
+	if n := testee.transitions(now.Add(4 * time.Second)); n != 1 {
+		t.Errorf("Expected 1 transition within window but was %d", n)
+	}
+}
+
+func TestHealthChecksFlapDetection(t *testing.T) {
+	// Flaps until the history is full and ends with a passing result
+	n := 0
+	release := make(chan bool)
+	ck := NewHealthCheck("flapping", duration("1ms"), 0, 1, func() *HealthCheckResult {
+		n++
+		if n > DEFAULT_HISTORY_SIZE {
+			<-release
+		}
+		if n%2 == 0 {
+			return NewHealthCheckResult(STATUS_PASSING, "ok")
+		}
+		return NewHealthCheckResult(STATUS_CRITICAL, "failed")
+	})
+	testee := NewHealthChecks(log.NewNopLogger(), func(r *HealthCheckResults) error { return nil }, 0, ck)
+	testee.SetFlapDetection(3, time.Minute)
+	testee.Start()
+	<-time.After(duration("50ms"))
+	status := testee.Status()
+	states := testee.States()
+	close(release)
+	testee.Stop()
+	if status.Status() != STATUS_WARNING {
+		t.Errorf("Flapping check should be held at warning but was %s", status.Status())
+	}
+	if len(states) != 1 || !states[0].Flapping {
+		t.Errorf("Check should be reported as flapping")
+	}
+	if len(states[0].History) != DEFAULT_HISTORY_SIZE {
+		t.Errorf("Expected %d history entries but was %d", DEFAULT_HISTORY_SIZE, len(states[0].History))
+	}
+}
+
+func TestHealthChecksFlapDetectionKeepsCritical(t *testing.T) {
+	ck := NewHealthCheck("flapping", time.Hour, 0, 1, func() *HealthCheckResult {
+		return NewHealthCheckResult(STATUS_CRITICAL, "starting")
+	})
+	updates := 0
+	testee := NewHealthChecks(log.NewNopLogger(), func(r *HealthCheckResults) error { return nil }, 0, ck)
+	testee.SetFlapDetection(1, time.Minute)
+	testee.Start()
+	defer testee.Stop()
+	<-time.After(duration("20ms"))
+	for i, c := range []struct {
+		status   HealthStatus
+		expected HealthStatus
+		updates  int
+	}{
+		{STATUS_CRITICAL, STATUS_CRITICAL, 0},
+		{STATUS_PASSING, STATUS_PASSING, 1},
+		{STATUS_CRITICAL, STATUS_CRITICAL, 2},
+		{STATUS_PASSING, STATUS_WARNING, 3}, // flapping
+		{STATUS_CRITICAL, STATUS_CRITICAL, 4},
+		{STATUS_CRITICAL, STATUS_CRITICAL, 4},
+	} {
+		r := NewHealthCheckResult(c.status, "")
+		r.time = time.Now()
+		changed, checkChanged := testee.updateStatus(r)
+		if changed || checkChanged {
+			updates++
+		}
+		if s := testee.Status().Status(); s != c.expected {
+			t.Errorf("%d: %s result should result in %s but was %s", i, c.status, c.expected, s)
+		}
+		if updates != c.updates {
+			t.Errorf("%d: expected %d status changes but was %d", i, c.updates, updates)
+		}
+	}
+}
+
+func TestHealthChecksUpdateListener(t *testing.T) {
+	ck := NewHealthCheck("failing", duration("1ms"), 0, 1, func() *HealthCheckResult {
+		return NewHealthCheckResult(STATUS_CRITICAL, "failed")
+	})
+	updates := 0
+	testee := NewHealthChecks(log.NewNopLogger(), func(r *HealthCheckResults) error { return nil }, 0, ck)
+	testee.SetUpdateListener(func(c *HealthChecks) { updates++ }, duration("20ms"))
+	testee.Start()
+	<-time.After(duration("110ms"))
+	testee.Stop()
+	if updates < 3 {
+		t.Errorf("Listener should be called repeatedly while check keeps failing but was called %d times", updates)
+	}
+	if updates > 10 {
+		t.Errorf("Listener calls should be throttled but was called %d times", updates)
+	}
+}
@@ -0,0 +1,85 @@
+package checks
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const DEFAULT_HISTORY_SIZE = 10
+
+type HealthCheckRecord struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Status   HealthStatus  `json:"status"`
+	Output   string        `json:"output"`
+}
+
+// A check's current state and latest results
+type HealthCheckState struct {
+	Name     string               `json:"name"`
+	Status   HealthStatus         `json:"status"`
+	Flapping bool                 `json:"flapping"`
+	History  []*HealthCheckRecord `json:"history"`
+}
+
+func (s HealthStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *HealthStatus) UnmarshalJSON(v []byte) error {
+	var name string
+	if err := json.Unmarshal(v, &name); err != nil {
+		return err
+	}
+	for i, n := range statusNameMap {
+		if n == name {
+			*s = HealthStatus(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid health status %q", name)
+}
+
+// Ring buffer containing a check's latest results
+type resultHistory struct {
+	records []*HealthCheckRecord
+	next    int
+	count   int
+}
+
+func newResultHistory(capacity uint) *resultHistory {
+	if capacity == 0 {
+		capacity = 1
+	}
+	return &resultHistory{make([]*HealthCheckRecord, capacity), 0, 0}
+}
+
+func (h *resultHistory) add(r *HealthCheckRecord) {
+	h.records[h.next] = r
+	h.next = (h.next + 1) % len(h.records)
+	if h.count < len(h.records) {
+		h.count++
+	}
+}
+
+// Returns the records ordered from oldest to latest
+func (h *resultHistory) list() []*HealthCheckRecord {
+	r := make([]*HealthCheckRecord, h.count)
+	start := (h.next - h.count + len(h.records)) % len(h.records)
+	for i := 0; i < h.count; i++ {
+		r[i] = h.records[(start+i)%len(h.records)]
+	}
+	return r
+}
+
+// Returns the number of status changes recorded since the given time
+func (h *resultHistory) transitions(since time.Time) (n uint) {
+	l := h.list()
+	for i := 1; i < len(l); i++ {
+		if l[i].Status != l[i-1].Status && !l[i].Time.Before(since) {
+			n++
+		}
+	}
+	return
+}
@@ -0,0 +1,47 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"time"
+)
+
+// Passes when a TCP connection to the address can be established
+func NewTcpHealthIndicator(address string, timeout time.Duration) HealthIndicator {
+	return func() *HealthCheckResult {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return NewHealthCheckResult(STATUS_CRITICAL, err.Error())
+		}
+		conn.Close()
+		return NewHealthCheckResult(STATUS_PASSING, "")
+	}
+}
+
+// Calls grpc.health.v1.Health/Check at the address.
+// Passes when the service is reported as SERVING.
+// An empty service name queries the server's overall health.
+func NewGrpcHealthIndicator(address, service string, timeout time.Duration) HealthIndicator {
+	return func() *HealthCheckResult {
+		conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return NewHealthCheckResult(STATUS_CRITICAL, "Cannot create grpc client: "+err.Error())
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req := &grpc_health_v1.HealthCheckRequest{Service: service}
+		res, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, req)
+		if err != nil {
+			return NewHealthCheckResult(STATUS_CRITICAL, err.Error())
+		}
+		if res.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+			return NewHealthCheckResult(STATUS_CRITICAL, fmt.Sprintf("grpc service status %s", res.Status))
+		}
+		return NewHealthCheckResult(STATUS_PASSING, "")
+	}
+}
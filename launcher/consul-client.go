@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -37,12 +39,78 @@ type Health struct {
 	Output string
 }
 
+// Exponential backoff with jitter used to retry failed consul requests
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	MaxRetries uint
+}
+
+func DefaultBackoff() *Backoff {
+	return &Backoff{500 * time.Millisecond, 10 * time.Second, 5}
+}
+
+// Returns the delay before the given retry (starting at 0).
+// The delay doubles with every attempt up to Max and is randomized
+// between 50% and 100% of its value to avoid synchronized retries.
+func (b *Backoff) Delay(attempt uint) time.Duration {
+	d := b.Initial
+	for i := uint(0); i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Error returned when consul responds with an unexpected status code
+type ConsulStatusError struct {
+	StatusCode int
+	Method     string
+	URL        string
+	Body       string
+}
+
+func (e *ConsulStatusError) Error() string {
+	msg := fmt.Sprintf("consul: status %d: %s %s", e.StatusCode, e.Method, e.URL)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Returns true if the error indicates that consul does not know the
+// service or check. This happens when the consul agent has been restarted
+// since it does not persist registrations made via the agent API.
+func IsConsulUnknownRegistration(err error) bool {
+	if e, ok := err.(*ConsulStatusError); ok {
+		return e.StatusCode == 404 || strings.Contains(strings.ToLower(e.Body), "unknown check")
+	}
+	return false
+}
+
+func isConsulRetryable(err error) bool {
+	if e, ok := err.(*ConsulStatusError); ok {
+		return e.StatusCode >= 500 && !IsConsulUnknownRegistration(err)
+	}
+	return true
+}
+
 type ConsulClient struct {
 	address string
 	client  *http.Client
+	backoff *Backoff
 }
 
-func NewConsulClient(address string) *ConsulClient {
+func NewConsulClient(address string, backoff *Backoff) *ConsulClient {
+	if backoff == nil {
+		backoff = DefaultBackoff()
+	}
 	return &ConsulClient{address, &http.Client{
 		Timeout: time.Duration(5 * time.Second),
 		Transport: &http.Transport{
@@ -51,21 +119,26 @@ func NewConsulClient(address string) *ConsulClient {
 			DisableCompression:  true,
 			TLSHandshakeTimeout: 5 * time.Second,
 		},
-	}}
+	}, backoff}
 }
 
-func (c *ConsulClient) CheckAvailability(maxRetries uint) bool {
-	for i := uint(0); i <= maxRetries; i++ {
-		_, err := c.request("GET", "kv/?keys", nil, 200)
+// Waits until consul is available or the timeout exceeded
+func (c *ConsulClient) CheckAvailability(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for i := uint(0); ; i++ {
+		_, err := c.doRequest("GET", "kv/?keys", nil, 200)
 		if err == nil {
 			return true
 		}
+		delay := c.backoff.Delay(i)
+		if time.Now().Add(delay).After(deadline) {
+			return false
+		}
 		if i == 0 {
-			os.Stderr.WriteString(fmt.Sprintf("Consul at %s unavailable. Retrying %d times...\n", c.address, maxRetries))
+			os.Stderr.WriteString(fmt.Sprintf("Consul at %s unavailable. Retrying for %s...\n", c.address, timeout))
 		}
-		<-time.After(time.Second)
+		<-time.After(delay)
 	}
-	return false
 }
 
 // TODO: best case: register service with health checks.
@@ -79,7 +152,7 @@ func (c *ConsulClient) RegisterService(s *ConsulService) error {
 	if err != nil {
 		return toError("unmarshallable service registration payload: %s", err)
 	}
-	_, err = c.request("PUT", "agent/service/register", bytes.NewReader(j), 200)
+	_, err = c.request("PUT", "agent/service/register", j, 200)
 	return err
 }
 
@@ -93,7 +166,7 @@ func (c *ConsulClient) ReportHealth(checkId string, r *Health) error {
 	if err != nil {
 		return toError("unmarshallable check update payload: %s", err)
 	}
-	_, err = c.request("PUT", "agent/check/update/"+checkId, bytes.NewReader(j), 200)
+	_, err = c.request("PUT", "agent/check/update/"+checkId, j, 200)
 	return err
 }
 
@@ -102,12 +175,26 @@ func (c *ConsulClient) GetKey(k string) (string, error) {
 }
 
 func (c *ConsulClient) SetKey(k, v string) error {
-	_, err := c.request("PUT", "kv/"+k, bytes.NewReader([]byte(v)), 200)
+	_, err := c.request("PUT", "kv/"+k, []byte(v), 200)
 	return err
 }
 
-func (c *ConsulClient) request(method, path string, body io.Reader, successStatusCodes ...int) (string, error) {
-	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", c.address, path), body)
+func (c *ConsulClient) request(method, path string, body []byte, successStatusCodes ...int) (r string, err error) {
+	for i := uint(0); ; i++ {
+		r, err = c.doRequest(method, path, body, successStatusCodes...)
+		if err == nil || i >= c.backoff.MaxRetries || !isConsulRetryable(err) {
+			return
+		}
+		<-time.After(c.backoff.Delay(i))
+	}
+}
+
+func (c *ConsulClient) doRequest(method, path string, body []byte, successStatusCodes ...int) (string, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", c.address, path), reqBody)
 	if err != nil {
 		return "", toError("invalid request: %s", err)
 	}
@@ -115,19 +202,15 @@ func (c *ConsulClient) request(method, path string, body io.Reader, successStatu
 	if err != nil {
 		return "", toError("request failed: %s", err)
 	}
-	success := false
+	defer r.Body.Close()
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
 	for _, successCode := range successStatusCodes {
 		if r.StatusCode == successCode {
-			success = true
-			break
+			return buf.String(), nil
 		}
 	}
-	if !success {
-		return "", toError("status %d: %s %s", r.StatusCode, req.Method, req.URL)
-	}
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(r.Body)
-	return buf.String(), nil
+	return "", &ConsulStatusError{r.StatusCode, req.Method, req.URL.String(), strings.TrimSpace(buf.String())}
 }
 
 func toError(f string, v ...interface{}) error {
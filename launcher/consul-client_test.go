@@ -0,0 +1,62 @@
+package launcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConsulClientRetriesOnServerError(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("value"))
+	}))
+	defer srv.Close()
+	testee := NewConsulClient(srv.URL, &Backoff{time.Millisecond, 5 * time.Millisecond, 5})
+	v, err := testee.GetKey("mykey")
+	if err != nil {
+		t.Errorf("GetKey returned error: %s", err)
+		return
+	}
+	if v != "value" {
+		t.Errorf("Unexpected value %q", v)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests but was %d", requests)
+	}
+}
+
+func TestConsulClientDetectsUnknownCheck(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(500)
+		w.Write([]byte("Unknown check \"service:rkt-x\""))
+	}))
+	defer srv.Close()
+	testee := NewConsulClient(srv.URL, &Backoff{time.Millisecond, 5 * time.Millisecond, 5})
+	err := testee.ReportHealth("service:rkt-x", &Health{CONSUL_STATUS_PASSING, ""})
+	if !IsConsulUnknownRegistration(err) {
+		t.Errorf("Expected unknown registration error but was: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Unknown check error should not be retried but %d requests were made", requests)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := &Backoff{100 * time.Millisecond, time.Second, 0}
+	for i, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max = max * time.Millisecond
+		d := b.Delay(uint(i))
+		if d < max/2 || d > max {
+			t.Errorf("Delay(%d) should be within [%s, %s] but was %s", i, max/2, max, d)
+		}
+	}
+}
@@ -12,52 +12,56 @@ type ConsulLifecycle struct {
 	descriptor        *Pod
 	podUUID           string
 	client            *ConsulClient
-	checks            *checks.HealthChecks
 	minReportInterval time.Duration
 	checkTTL          time.Duration
 	service           *ConsulService
 	debug             log.Logger
 }
 
-func NewConsulLifecycleFactory(address string, checkTTL time.Duration, debug log.Logger) (LifecycleListenerFactory, error) {
-	client := NewConsulClient(address)
-	if !client.CheckAvailability(30) {
+type ConsulConfig struct {
+	Address string
+	// Max duration to wait for consul to become available on startup
+	StartupTimeout time.Duration
+	CheckTTL       time.Duration
+	// Retry strategy applied to failed consul requests
+	Backoff *Backoff
+}
+
+func NewConsulLifecycleFactory(cfg *ConsulConfig, debug log.Logger) (LifecycleListenerFactory, error) {
+	client := NewConsulClient(cfg.Address, cfg.Backoff)
+	if !client.CheckAvailability(cfg.StartupTimeout) {
 		return nil, errors.New("Consul unavailable")
 	}
+	checkTTL := cfg.CheckTTL
 	return func(pod *Pod) LifecycleListener {
-		// Health checks done within the launcher to be able to run commands within the container
 		minReportInterval := checkTTL / 2
-		c := &ConsulLifecycle{pod, "", client, nil, minReportInterval, checkTTL, nil, debug}
+		c := &ConsulLifecycle{pod, "", client, minReportInterval, checkTTL, nil, debug}
 		return c
 	}, nil
 }
 
 func (c *ConsulLifecycle) Start(podUUID, podIP string) (err error) {
 	c.podUUID = podUUID
-	c.checks, err = toHealthChecks(c.descriptor, podUUID, c.reportHealth, c.minReportInterval, c.debug)
-	if err != nil {
-		return
-	}
 	tags := toTags(c.descriptor.Services)
 	checkTTL := c.checkTTL.String()
 	checkNote := fmt.Sprintf("Aggregated checks (Interval: %s, TTL: %s)", c.minReportInterval.String(), checkTTL)
 	check := HeartBeat{checkNote, checkTTL}
-	service := &ConsulService{c.serviceId(), c.descriptor.Name, podIP, tags, false, check}
-	err = c.client.RegisterService(service)
-	if err != nil {
-		return
+	c.service = &ConsulService{c.serviceId(), c.descriptor.Name, podIP, tags, false, check}
+	return c.register()
+}
+
+func (c *ConsulLifecycle) register() error {
+	if err := c.client.RegisterService(c.service); err != nil {
+		return err
 	}
-	err = c.registerSharedKeys()
-	if err != nil {
+	if err := c.registerSharedKeys(); err != nil {
 		c.client.DeregisterService(c.serviceId())
-		return
+		return err
 	}
-	c.checks.Start()
 	return nil
 }
 
 func (c *ConsulLifecycle) Terminate() error {
-	c.checks.Stop()
 	serviceId := c.serviceId()
 	c.debug.Printf("Deregistering service %q...", serviceId)
 	if err := c.client.DeregisterService(serviceId); err != nil {
@@ -66,10 +70,27 @@ func (c *ConsulLifecycle) Terminate() error {
 	return nil
 }
 
-func (c *ConsulLifecycle) reportHealth(r *checks.HealthCheckResults) error {
+// Health checks are done within the launcher to be able to run commands within the container.
+// Since consul expects TTL check updates the status is reported at least every minReportInterval.
+func (c *ConsulLifecycle) HealthReportInterval() time.Duration {
+	return c.minReportInterval
+}
+
+func (c *ConsulLifecycle) ReportHealth(r *checks.HealthCheckResults) error {
 	status := r.Status().String()
 	c.debug.Printf("Reporting status %s...", status)
-	return c.client.ReportHealth("service:"+c.serviceId(), &Health{ConsulHealthStatus(status), r.Output()})
+	health := &Health{ConsulHealthStatus(status), r.Output()}
+	checkId := "service:" + c.serviceId()
+	err := c.client.ReportHealth(checkId, health)
+	if IsConsulUnknownRegistration(err) {
+		// Consul lost the registration (e.g. agent restarted) - register again
+		c.debug.Printf("Consul does not know check %q. Registering service again...", checkId)
+		if err = c.register(); err != nil {
+			return fmt.Errorf("re-register service: %s", err)
+		}
+		err = c.client.ReportHealth(checkId, health)
+	}
+	return err
 }
 
 func (c *ConsulLifecycle) serviceId() string {
@@ -93,36 +114,6 @@ func (c *ConsulLifecycle) registerSharedKeys() error {
 	return nil
 }
 
-func toHealthChecks(pod *Pod, podUUID string, reporter checks.HealthReporter, minReportInterval time.Duration, debug log.Logger) (*checks.HealthChecks, error) {
-	c := []*checks.HealthCheck{}
-	i := 1
-	for k, s := range pod.Services {
-		h := s.HealthCheck
-		if h != nil && len(h.Command) > 0 {
-			indicator, err := toHealthIndicator(pod, k, podUUID, h, debug)
-			if err != nil {
-				return nil, err
-			}
-			check := checks.NewHealthCheck(k, time.Duration(h.Interval), indicator)
-			c = append(c, check)
-			i++
-		}
-	}
-	return checks.NewHealthChecks(debug, reporter, minReportInterval, c...), nil
-}
-
-func toHealthIndicator(pod *Pod, app, podUUID string, h *HealthCheckDescriptor, debug log.Logger) (checks.HealthIndicator, error) {
-	switch {
-	case len(h.Command) > 0:
-		cmd := append([]string{"rkt", "enter", "--app=" + app, podUUID}, h.Command...)
-		return checks.NewCommandBasedHealthIndicator(debug, time.Duration(h.Timeout), cmd...), nil
-	case len(h.Http) > 0:
-		return nil, errors.New("HTTP health check unsupported")
-	default:
-		return nil, fmt.Errorf("no health check indicator defined for %q", app)
-	}
-}
-
 func toTags(m map[string]*Service) []string {
 	t := make([]string, len(m))
 	i := 0
@@ -0,0 +1,80 @@
+package launcher
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// Returns a command that runs cmd within the service's container of a running pod.
+// The service's environment is appended to the current process' environment.
+func ExecCommand(pod *Pod, podUUID, service string, cmd []string, tty bool) (*exec.Cmd, error) {
+	s := pod.Services[service]
+	if s == nil {
+		return nil, fmt.Errorf("Pod %q has no service %q", pod.Name, service)
+	}
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("No command provided to execute in service %q", service)
+	}
+	env := map[string]string{}
+	for k, v := range pod.Environment {
+		env[k] = v
+	}
+	for k, v := range s.Environment {
+		env[k] = v
+	}
+	if term := os.Getenv("TERM"); tty && term != "" {
+		env["TERM"] = term
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	args := newArgs("enter", "--app="+service, podUUID)
+	args.add(cmd...)
+	c := exec.Command("rkt", args.toSlice()...)
+	c.Env = os.Environ()
+	for _, k := range keys {
+		c.Env = append(c.Env, k+"="+env[k])
+	}
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c, nil
+}
+
+// Derives a throwaway pod from the given pod that contains only the service.
+// The service keeps its environment and volumes but publishes no ports and
+// runs the provided command (if any) instead of its default command.
+func OneOffPod(pod *Pod, service string, cmd []string) (*Pod, error) {
+	s := pod.Services[service]
+	if s == nil {
+		return nil, fmt.Errorf("Pod %q has no service %q", pod.Name, service)
+	}
+	r := *pod
+	rs := *s
+	if len(cmd) > 0 {
+		rs.Command = cmd
+	}
+	rs.Ports = []*PortBinding{}
+	rs.HealthCheck = nil
+	rs.OnUnhealthy = &UnhealthyPolicy{UNHEALTHY_IGNORE, 0}
+	r.Name = pod.Name + "-" + service + "-run"
+	r.Services = map[string]*Service{service: &rs}
+	r.Volumes = map[string]*Volume{}
+	for _, volName := range s.Mounts {
+		if v := pod.Volumes[volName]; v != nil {
+			r.Volumes[volName] = v
+		}
+	}
+	r.SharedKeys = map[string]string{}
+	return &r, nil
+}
+
+// Returns true if stdin is a terminal
+func IsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,67 @@
+package launcher
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func testPod() *Pod {
+	pod := &Pod{Name: "mypod", Environment: map[string]string{"A": "pod", "B": "pod"}}
+	s1 := NewService()
+	s1.Environment["B"] = "svc"
+	s1.Command = []string{"serve"}
+	s1.Mounts["/data"] = "data"
+	s1.Ports = []*PortBinding{&PortBinding{80, 8080, "", "tcp"}}
+	s2 := NewService()
+	s2.Mounts["/other"] = "other"
+	pod.Services = map[string]*Service{"s1": s1, "s2": s2}
+	pod.Volumes = map[string]*Volume{"data": &Volume{"./data", "host", false}, "other": &Volume{"./other", "host", false}}
+	pod.SharedKeys = map[string]string{"key": "value"}
+	return pod
+}
+
+func TestExecCommand(t *testing.T) {
+	c, err := ExecCommand(testPod(), "uuid", "s1", []string{"ls", "-la"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"rkt", "enter", "--app=s1", "uuid", "ls", "-la"}
+	if !reflect.DeepEqual(c.Args, expected) {
+		t.Errorf("expected args %v but was %v", expected, c.Args)
+	}
+	expectedEnv := append(os.Environ(), "A=pod", "B=svc")
+	if !reflect.DeepEqual(c.Env, expectedEnv) {
+		t.Errorf("expected env %v but was %v", expectedEnv, c.Env)
+	}
+	if _, err = ExecCommand(testPod(), "uuid", "unknown", []string{"ls"}, false); err == nil {
+		t.Error("should return error for unknown service")
+	}
+}
+
+func TestOneOffPod(t *testing.T) {
+	pod := testPod()
+	r, err := OneOffPod(pod, "s1", []string{"migrate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Services) != 1 || r.Services["s1"] == nil {
+		t.Fatalf("one-off pod should contain only service s1 but contained %v", r.Services)
+	}
+	s := r.Services["s1"]
+	if !reflect.DeepEqual(s.Command, []string{"migrate"}) {
+		t.Errorf("command should be overridden but was %v", s.Command)
+	}
+	if len(s.Ports) != 0 {
+		t.Errorf("one-off service should not publish ports")
+	}
+	if len(r.Volumes) != 1 || r.Volumes["data"] == nil {
+		t.Errorf("one-off pod should contain only the service's volumes but contained %v", r.Volumes)
+	}
+	if r.Name == pod.Name {
+		t.Errorf("one-off pod name should differ from the original pod's name")
+	}
+	if len(pod.Services) != 2 || !reflect.DeepEqual(pod.Services["s1"].Command, []string{"serve"}) {
+		t.Errorf("original pod should not be modified")
+	}
+}
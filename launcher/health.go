@@ -0,0 +1,125 @@
+package launcher
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/checks"
+	"github.com/mgoltzsche/rkt-compose/log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Min duration between two health state file writes unless a status changed
+const HEALTH_STATE_MIN_WRITE_INTERVAL = time.Second
+
+// Optionally implemented by a LifecycleListener to receive health check results
+type HealthListener interface {
+	ReportHealth(r *checks.HealthCheckResults) error
+	// Max duration between two reports
+	HealthReportInterval() time.Duration
+}
+
+func (ctx *PodLauncher) startHealthChecks() (err error) {
+	reporter := func(r *checks.HealthCheckResults) error { return nil }
+	var reportInterval time.Duration
+	if l, ok := ctx.listener.(HealthListener); ok {
+		reporter = l.ReportHealth
+		reportInterval = l.HealthReportInterval()
+	} else if ctx.stateDir == "" && !hasUnhealthyPolicy(ctx.descriptor) {
+		// Nobody is interested in health check results
+		return nil
+	}
+	ctx.health, err = toHealthChecks(ctx.descriptor, ctx.podUUID, ctx.podIP, reporter, reportInterval, ctx.onUnhealthy, ctx.debug)
+	if err != nil {
+		return
+	}
+	if ctx.healthHistory > 0 {
+		ctx.health.SetHistorySize(ctx.healthHistory)
+	}
+	ctx.health.SetFlapDetection(ctx.flapThreshold, ctx.flapWindow)
+	if ctx.stateDir != "" {
+		ctx.health.SetUpdateListener(ctx.writeHealthState, HEALTH_STATE_MIN_WRITE_INTERVAL)
+	}
+	ctx.health.Start()
+	if ctx.stateDir != "" {
+		ctx.writeHealthState(ctx.health)
+	}
+	return nil
+}
+
+func (ctx *PodLauncher) stopHealthChecks() {
+	if ctx.health != nil {
+		ctx.health.Stop()
+		ctx.health = nil
+	}
+}
+
+func (ctx *PodLauncher) onUnhealthy(service string, unhealthyFor time.Duration, output string) {
+	policy := ctx.descriptor.Services[service].OnUnhealthy
+	reason := fmt.Sprintf("service %q unhealthy for %s: %s", service, unhealthyFor, output)
+	switch policy.Action {
+	case UNHEALTHY_RESTART:
+		ctx.error.Printf("Restarting pod since %s", reason)
+		ctx.mutex.Lock()
+		ctx.restart = true
+		ctx.mutex.Unlock()
+		if err := ctx.stop(); err != nil {
+			ctx.error.Printf("Failed to stop pod for restart: %s", err)
+		}
+	case UNHEALTHY_STOP_POD:
+		ctx.error.Printf("Stopping pod since %s", reason)
+		ctx.mutex.Lock()
+		ctx.stopErr = errors.New(reason)
+		ctx.mutex.Unlock()
+		if err := ctx.Stop(); err != nil {
+			ctx.error.Printf("Failed to stop pod: %s", err)
+		}
+	}
+}
+
+func hasUnhealthyPolicy(pod *Pod) bool {
+	for _, s := range pod.Services {
+		if s.OnUnhealthy != nil && s.OnUnhealthy.Action != UNHEALTHY_IGNORE {
+			return true
+		}
+	}
+	return false
+}
+
+func toHealthChecks(pod *Pod, podUUID, podIP string, reporter checks.HealthReporter, minReportInterval time.Duration, onUnhealthy checks.UnhealthyHandler, debug log.Logger) (*checks.HealthChecks, error) {
+	c := []*checks.HealthCheck{}
+	for k, s := range pod.Services {
+		h := s.HealthCheck
+		if h != nil && (len(h.Command) > 0 || h.Tcp > 0 || h.Grpc > 0) {
+			indicator, err := toHealthIndicator(pod, k, podUUID, podIP, h, debug)
+			if err != nil {
+				return nil, err
+			}
+			check := checks.NewHealthCheck(k, h.Interval, h.StartPeriod, h.Retries, indicator)
+			if s.OnUnhealthy != nil && s.OnUnhealthy.Action != UNHEALTHY_IGNORE {
+				check.SetUnhealthyHandler(s.OnUnhealthy.Threshold, onUnhealthy)
+			}
+			c = append(c, check)
+		}
+	}
+	return checks.NewHealthChecks(debug, reporter, minReportInterval, c...), nil
+}
+
+func toHealthIndicator(pod *Pod, app, podUUID, podIP string, h *HealthCheckDescriptor, debug log.Logger) (checks.HealthIndicator, error) {
+	switch {
+	case len(h.Command) > 0:
+		cmd := append([]string{"rkt", "enter", "--app=" + app, podUUID}, h.Command...)
+		return checks.NewCommandBasedHealthIndicator(debug, time.Duration(h.Timeout), cmd...), nil
+	case h.Tcp > 0:
+		addr := net.JoinHostPort(podIP, strconv.Itoa(int(h.Tcp)))
+		return checks.NewTcpHealthIndicator(addr, h.Timeout), nil
+	case h.Grpc > 0:
+		addr := net.JoinHostPort(podIP, strconv.Itoa(int(h.Grpc)))
+		return checks.NewGrpcHealthIndicator(addr, h.GrpcService, h.Timeout), nil
+	case len(h.Http) > 0:
+		return nil, errors.New("HTTP health check unsupported")
+	default:
+		return nil, fmt.Errorf("no health check indicator defined for %q", app)
+	}
+}
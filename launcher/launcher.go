@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/mgoltzsche/rkt-compose/checks"
 	"github.com/mgoltzsche/rkt-compose/log"
 	"io/ioutil"
 	"os"
@@ -18,23 +19,6 @@ import (
 	"time"
 )
 
-const rktNetworkConfig = `
-{
-    "name": "compose-bridge",
-    "type": "bridge",
-    "bridge": "rkt-compose",
-    "ipMasq": true,
-    "isGateway": true,
-    "ipam": {
-        "type": "host-local",
-        "subnet": "10.3.0.0/24",
-        "routes": [
-                { "dst": "0.0.0.0/0" }
-        ]
-    }
-}
-`
-
 type LifecycleListenerFactory func(pod *Pod) LifecycleListener
 
 type LifecycleListener interface {
@@ -53,6 +37,7 @@ type ContainerInfo struct {
 	Networks  []*ContainerNetwork `json:"networks"`
 	AppNames  []string            `json:"app_names"`
 	StartedAt uint64              `json:"started_at"`
+	Pid       int                 `json:"pid,omitempty"`
 }
 
 type ContainerNetwork struct {
@@ -68,15 +53,25 @@ type ContainerNetwork struct {
 type PodLauncher struct {
 	descriptor       *Pod
 	listener         LifecycleListener
+	health           *checks.HealthChecks
 	podUUID          string
+	podIP            string
 	podUUIDFile      string
 	hostsFile        string
 	rktConfDir       string
+	stateDir         string
+	healthHistory    uint
+	flapThreshold    uint
+	flapWindow       time.Duration
 	defaultPublishIP string
+	interactive      bool
 	cmd              *exec.Cmd
 	mutex            *sync.Mutex
 	once             *sync.Once
 	err              error
+	restart          bool
+	stopped          bool
+	stopErr          error
 	wait             sync.WaitGroup
 	debug            log.Logger
 	info             log.Logger
@@ -87,10 +82,19 @@ type Config struct {
 	Pod              *Pod
 	UUIDFile         string
 	DefaultPublishIP string
-	ListenerFactory  LifecycleListenerFactory
-	Debug            log.Logger
-	Info             log.Logger
-	Error            log.Logger
+	// Directory the running pod's state is published to
+	StateDir string
+	// Number of results kept per health check
+	HealthHistorySize uint
+	// Status changes within FlapWindow after which a check is considered flapping. 0 disables flap detection
+	FlapThreshold uint
+	FlapWindow    time.Duration
+	// Attaches stdin to the pod. Requires the pod to contain a single service
+	Interactive     bool
+	ListenerFactory LifecycleListenerFactory
+	Debug           log.Logger
+	Info            log.Logger
+	Error           log.Logger
 }
 
 func NewPodLauncher(cfg *Config) (*PodLauncher, error) {
@@ -99,6 +103,20 @@ func NewPodLauncher(cfg *Config) (*PodLauncher, error) {
 	r.error = cfg.Error
 	r.descriptor = cfg.Pod
 	r.defaultPublishIP = cfg.DefaultPublishIP
+	r.interactive = cfg.Interactive
+	if r.interactive && len(cfg.Pod.Services) != 1 {
+		return nil, errors.New("Interactive mode requires a pod with a single service")
+	}
+	r.healthHistory = cfg.HealthHistorySize
+	r.flapThreshold = cfg.FlapThreshold
+	r.flapWindow = cfg.FlapWindow
+	if cfg.StateDir != "" {
+		stateDir, err := filepath.Abs(cfg.StateDir)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid state directory: %s", err)
+		}
+		r.stateDir = stateDir
+	}
 	if cfg.UUIDFile != "" {
 		uuidFile, err := filepath.Abs(cfg.UUIDFile)
 		if err != nil {
@@ -131,17 +149,10 @@ func (ctx *PodLauncher) Start() (err error) {
 	if len(ctx.podUUID) > 0 {
 		return fmt.Errorf("launcher: pod already running: %s", ctx.podUUID)
 	}
-	ctx.err = nil
-	/*ctx.rktConfDir, err = ctx.writeRktDefaultNetworkConfig()
-	if err != nil {
-		return err
-	}
-	//defer os.RemoveAll(ctx.rktConfDir)
-	*/
-	runArgsBuilder, err := ctx.toRktRunArgs()
-	if err != nil {
-		return
+	if ctx.stopped {
+		return errors.New("launcher: pod has been stopped")
 	}
+	ctx.err = nil
 	err = ctx.createVolumeDirectories()
 	if err != nil {
 		return
@@ -151,10 +162,24 @@ func (ctx *PodLauncher) Start() (err error) {
 		return err
 	}
 	defer os.Remove(ctx.hostsFile)
-	err = ctx.prepare()
+	gcRktNetworkConfigs(ctx.networkConfigBaseDir(), podExists)
+	ctx.rktConfDir, err = writeRktNetworkConfig(ctx.networkConfigBaseDir(), ctx.descriptor.Networks)
 	if err != nil {
 		return
 	}
+	runArgsBuilder, err := ctx.toRktRunArgs()
+	if err == nil {
+		err = ctx.prepare()
+	}
+	if err == nil && ctx.rktConfDir != "" {
+		err = writeRktNetworkConfigOwner(ctx.rktConfDir, ctx.podUUID)
+	}
+	if err != nil {
+		if ctx.rktConfDir != "" {
+			os.RemoveAll(ctx.rktConfDir)
+		}
+		return
+	}
 	runArgs := runArgsBuilder.add(ctx.podUUID).toSlice()
 	ctx.debug.Println("Starting pod: rkt ", strings.Join(runArgs, "\n  "))
 	ctx.wait.Add(1)
@@ -168,17 +193,66 @@ func (ctx *PodLauncher) Start() (err error) {
 		} else {
 			return fmt.Errorf("rkt run: %s", ctx.err)
 		}
-		return fmt.Errorf("start status: %s", err)
 	}
-	if err = ctx.listener.Start(ctx.podUUID, info.Networks[0].IP); err != nil {
+	ctx.podIP = info.Networks[0].IP
+	if err = ctx.writePodState(); err != nil {
+		ctx.terminate()
+		return
+	}
+	if err = ctx.listener.Start(ctx.podUUID, ctx.podIP); err != nil {
+		ctx.removeState()
 		ctx.terminate()
 		return fmt.Errorf("start listener: %s", err)
 	}
+	if err = ctx.startHealthChecks(); err != nil {
+		ctx.invokeTerminationListener()
+		ctx.terminate()
+		return fmt.Errorf("start health checks: %s", err)
+	}
 	ctx.once = &sync.Once{}
 	return nil
 }
 
+// Starts the pod and waits for it to terminate.
+// The pod is started again when it has been stopped due to the restart
+// policy of an unhealthy service.
+func (ctx *PodLauncher) Run() (err error) {
+	if err = ctx.Start(); err != nil {
+		return
+	}
+	return ctx.Supervise()
+}
+
+// Waits for the started pod to terminate and starts it again when it has
+// been stopped due to the restart policy of an unhealthy service.
+func (ctx *PodLauncher) Supervise() (err error) {
+	for {
+		err = ctx.Wait()
+		ctx.mutex.Lock()
+		restart := ctx.restart && !ctx.stopped
+		ctx.restart = false
+		if ctx.stopErr != nil {
+			err = ctx.stopErr
+		}
+		ctx.mutex.Unlock()
+		if !restart {
+			return
+		}
+		if err = ctx.Start(); err != nil {
+			return
+		}
+	}
+}
+
 func (ctx *PodLauncher) Stop() (err error) {
+	ctx.mutex.Lock()
+	ctx.stopped = true
+	ctx.restart = false
+	ctx.mutex.Unlock()
+	return ctx.stop()
+}
+
+func (ctx *PodLauncher) stop() (err error) {
 	ctx.debug.Println("Stopping pod...")
 	ctx.mutex.Lock()
 	defer ctx.mutex.Unlock()
@@ -222,6 +296,9 @@ func (ctx *PodLauncher) prepare() error {
 
 func (ctx *PodLauncher) run() {
 	defer ctx.onPodTerminated()
+	if ctx.interactive {
+		ctx.cmd.Stdin = os.Stdin
+	}
 	ctx.cmd.Stdout = os.Stdout
 	ctx.cmd.Stderr = os.Stderr
 	ctx.err = ctx.cmd.Run()
@@ -234,37 +311,49 @@ func (ctx *PodLauncher) onPodTerminated() {
 
 func (ctx *PodLauncher) terminate() (err error) {
 	if ctx.cmd != nil && ctx.cmd.Process != nil {
+		ctx.stopApps()
 		ctx.debug.Println("Terminating rkt process...")
-		err = exec.Command("rkt", "stop", ctx.podUUID).Run()
+		if err = exec.Command("rkt", "stop", ctx.podUUID).Run(); err != nil && ctx.waitForPod(time.Second) {
+			// Pod already terminated since all apps exited
+			return nil
+		}
 		if err != nil {
 			ctx.error.Println("Killing pod since termination failed: ", err)
-			err = ctx.cmd.Process.Kill()
-			if err != nil && !ctx.cmd.ProcessState.Exited() {
-				err = fmt.Errorf("Failed to kill rkt process: %s", err)
-			}
+		} else if ctx.waitForPod(time.Duration(ctx.descriptor.StopGracePeriod)) {
 			return
-		}
-		quit := make(chan bool, 1)
-		go func() {
-			ctx.wait.Wait()
-			quit <- true
-		}()
-		select {
-		case <-time.After(time.Duration(ctx.descriptor.StopGracePeriod)):
+		} else {
 			ctx.error.Println("Killing pod since stop timeout exceeded")
-			err = ctx.cmd.Process.Kill()
-			if err != nil && !ctx.cmd.ProcessState.Exited() {
-				err = fmt.Errorf("Failed to kill rkt process: %s", err)
-			}
-			<-quit
-		case <-quit:
 		}
-		close(quit)
+		// Last resort: apps exceeding their grace period have been killed already
+		err = ctx.cmd.Process.Kill()
+		if err != nil && (ctx.cmd.ProcessState == nil || !ctx.cmd.ProcessState.Exited()) {
+			err = fmt.Errorf("Failed to kill rkt process: %s", err)
+		} else {
+			err = nil
+		}
+		ctx.wait.Wait()
 	}
 	return
 }
 
+// Waits for the rkt process to terminate. Returns false if the timeout exceeded.
+func (ctx *PodLauncher) waitForPod(timeout time.Duration) bool {
+	quit := make(chan bool, 1)
+	go func() {
+		ctx.wait.Wait()
+		quit <- true
+	}()
+	select {
+	case <-time.After(timeout):
+		return false
+	case <-quit:
+		return true
+	}
+}
+
 func (ctx *PodLauncher) invokeTerminationListener() {
+	ctx.stopHealthChecks()
+	ctx.removeState()
 	if err := ctx.listener.Terminate(); err != nil {
 		ctx.error.Println(err)
 	}
@@ -278,18 +367,8 @@ func (ctx *PodLauncher) Wait() error {
 func (ctx *PodLauncher) containerInfo() (r *ContainerInfo, err error) {
 	interval := time.Millisecond * 50
 	for i := 0; i < 40; i++ { // Loop is workaround since initial command call may list no networks
-		r = &ContainerInfo{}
-		cmd := exec.Command("rkt", "status", "--format=json", "--wait-ready=5s", ctx.podUUID)
-		var buf bytes.Buffer
-		cmd.Stderr = &buf
-		out, e := cmd.Output()
-		if e != nil {
-			err = fmt.Errorf("Failed to request rkt pod status: %s. %s", e, buf)
-			return
-		}
-		err = json.Unmarshal(out, r)
+		r, err = PodContainerInfo(ctx.podUUID, "--wait-ready=5s")
 		if err != nil {
-			err = fmt.Errorf("Failed to unmarshal rkt status. %s. Output: %s", err, string(out))
 			return
 		}
 		if r.State == "running" && len(r.Networks) > 0 {
@@ -305,6 +384,37 @@ func (ctx *PodLauncher) containerInfo() (r *ContainerInfo, err error) {
 	return
 }
 
+// Returns the directory the pod's network configs are written to
+func (ctx *PodLauncher) networkConfigBaseDir() string {
+	if ctx.stateDir == "" {
+		return ""
+	}
+	// Hidden directories are no pod states
+	return filepath.Join(ctx.stateDir, ".net")
+}
+
+// Returns true if rkt still knows the pod
+func podExists(podUUID string) bool {
+	return exec.Command("rkt", "status", podUUID).Run() == nil
+}
+
+// Returns the rkt status of the pod with the given UUID
+func PodContainerInfo(podUUID string, args ...string) (*ContainerInfo, error) {
+	r := &ContainerInfo{}
+	args = append(append([]string{"status", "--format=json"}, args...), podUUID)
+	cmd := exec.Command("rkt", args...)
+	var buf bytes.Buffer
+	cmd.Stderr = &buf
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to request rkt pod status: %s. %s", err, buf.String())
+	}
+	if err = json.Unmarshal(out, r); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal rkt status. %s. Output: %s", err, string(out))
+	}
+	return r, nil
+}
+
 func (ctx *PodLauncher) writeUuidFile() error {
 	if ctx.podUUIDFile != "" {
 		return ioutil.WriteFile(ctx.podUUIDFile, []byte(ctx.podUUID), 0644)
@@ -347,13 +457,17 @@ func (ctx *PodLauncher) createVolumeDirectories() error {
 
 func (ctx *PodLauncher) toRktRunArgs() (*args, error) {
 	pod := ctx.descriptor
-	r := newArgs(
-		"run-prepared",
-		//		"--user-config="+ctx.rktConfDir,
-		"--hostname="+pod.Hostname)
+	r := newArgs("run-prepared", "--hostname="+pod.Hostname)
+	if ctx.rktConfDir != "" {
+		r.add("--user-config=" + ctx.rktConfDir)
+	}
+	if ctx.interactive {
+		r.add("--interactive")
+	}
 	for _, net := range pod.Net {
 		r.add("--net=" + net)
 	}
+	r.add(netArgs(pod.Networks)...)
 	for _, dnsIP := range pod.Dns {
 		r.add("--dns=" + dnsIP)
 	}
@@ -363,11 +477,18 @@ func (ctx *PodLauncher) toRktRunArgs() (*args, error) {
 	return r, nil
 }
 
+// Returns true if rkt's experimental attach feature is enabled.
+// It is required to prepare apps with stdin or tty.
+func attachExperimentEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("RKT_EXPERIMENT_ATTACH"))
+	return enabled
+}
+
 func (ctx *PodLauncher) toRktPrepareArgs() ([]string, error) {
 	pod := ctx.descriptor
 	hostsVolName := filepath.Base(ctx.hostsFile)
 	r := newArgs("prepare", "--quiet=true")
-	if containsDockerImage(pod) {
+	if containsInsecureImage(pod) {
 		r.add("--insecure-options=image")
 	}
 	for k, v := range pod.Environment {
@@ -395,8 +516,33 @@ func (ctx *PodLauncher) toRktPrepareArgs() ([]string, error) {
 		}
 	}
 	for name, s := range pod.Services {
-		r.add(s.Image)
+		if s.ImageID == "" {
+			r.add(s.Image)
+		} else {
+			// Refer to the fetched and verified image
+			r.add(s.ImageID)
+		}
 		r.add("--name=" + name)
+		if s.User != "" {
+			r.add("--user=" + s.User)
+		}
+		if s.Group != "" {
+			r.add("--group=" + s.Group)
+		}
+		if s.WorkingDir != "" {
+			r.add("--working-dir=" + s.WorkingDir)
+		}
+		if !ctx.interactive && (s.Tty || s.StdinOpen) {
+			// Streams can be attached using rkt attach
+			if !attachExperimentEnabled() {
+				return nil, fmt.Errorf("Service %q: tty and stdin_open require rkt's attach experiment. Set RKT_EXPERIMENT_ATTACH=true", name)
+			}
+			if s.Tty {
+				r.add("--stdin=tty", "--stdout=tty", "--stderr=tty")
+			} else if s.StdinOpen {
+				r.add("--stdin=stream")
+			}
+		}
 		for k, v := range s.Environment {
 			r.add(fmt.Sprintf("--environment=%s=%s", k, v))
 		}
@@ -440,6 +586,12 @@ func (ctx *PodLauncher) generateHostsTempFile() error {
 	hosts := "# Generated by rkt-compose\n127.0.0.1 " + names + " localhost localhost.domain localhost4 localhost4.localdomain4\n\n"
 	hosts += "::1 ip6-localhost ip6-loopback localhost6 localhost6.localdomain6\n"
 	hosts += "fe00::0 ip6-localnet\nff00::0 ip6-mcastprefix\nff02::1 ip6-allnodes\nff02::2 ip6-allrouters\nff02::3 ip6-allhosts\n"
+	if len(pod.ExtraHosts) > 0 {
+		hosts += "\n"
+		for _, e := range pod.ExtraHosts {
+			hosts += e.IP + " " + strings.Join(e.Names, " ") + "\n"
+		}
+	}
 	f, err := ioutil.TempFile("", "pod-hosts-")
 	if err != nil {
 		return fmt.Errorf("Cannot create temporary hosts file: %s", err)
@@ -454,22 +606,6 @@ func (ctx *PodLauncher) generateHostsTempFile() error {
 	return nil
 }
 
-func (ctx *PodLauncher) writeRktDefaultNetworkConfig() (string, error) {
-	tmpDir, err := ioutil.TempDir("", "pod-cfg-")
-	if err != nil {
-		return "", fmt.Errorf("Cannot create temp rkt config dir: %s", err)
-	}
-	err = os.Mkdir(filepath.Join(tmpDir, "net.d"), 775)
-	if err != nil {
-		return "", fmt.Errorf("Cannot create temp rkt config net.d dir: %s", err)
-	}
-	if err = ioutil.WriteFile(filepath.Join(tmpDir, "net.d", "10-compose-bridge.conf"), []byte(rktNetworkConfig), 444); err != nil {
-		os.RemoveAll(tmpDir)
-		return "", fmt.Errorf("Cannot write temporary rkt network config: %s", err)
-	}
-	return tmpDir, nil
-}
-
 func absFile(p string, pod *Pod) string {
 	if len(p) > 0 && p[0:1] == "/" {
 		p = path.Clean(p)
@@ -479,9 +615,10 @@ func absFile(p string, pod *Pod) string {
 	return filepath.FromSlash(p)
 }
 
-func containsDockerImage(pod *Pod) bool {
+// Returns true if a service's image that is referred to by name is insecure
+func containsInsecureImage(pod *Pod) bool {
 	for _, s := range pod.Services {
-		if strings.Index(s.Image, "docker://") == 0 {
+		if s.ImageID == "" && s.Insecure {
 			return true
 		}
 	}
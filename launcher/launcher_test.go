@@ -0,0 +1,28 @@
+package launcher
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestToRktPrepareArgsRequiresAttachExperiment(t *testing.T) {
+	defer os.Setenv("RKT_EXPERIMENT_ATTACH", os.Getenv("RKT_EXPERIMENT_ATTACH"))
+	s := NewService()
+	s.Image = "example.org/app"
+	s.Tty = true
+	s.Entrypoint = []string{"/bin/app"}
+	ctx := &PodLauncher{descriptor: &Pod{Services: map[string]*Service{"app": s}}, hostsFile: "/tmp/hosts"}
+	os.Setenv("RKT_EXPERIMENT_ATTACH", "")
+	if _, err := ctx.toRktPrepareArgs(); err == nil || !strings.Contains(err.Error(), "RKT_EXPERIMENT_ATTACH") {
+		t.Errorf("should return error when attach experiment is disabled but returned %v", err)
+	}
+	os.Setenv("RKT_EXPERIMENT_ATTACH", "true")
+	args, err := ctx.toRktPrepareArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := strings.Join(args, " "); !strings.Contains(a, "--stdin=tty --stdout=tty --stderr=tty") {
+		t.Errorf("should attach tty but args were %s", a)
+	}
+}
@@ -1,16 +1,16 @@
 package launcher
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"github.com/mgoltzsche/rkt-compose/log"
 	"github.com/mgoltzsche/rkt-compose/model"
-	"os"
 	"path"
-	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,31 +20,36 @@ type Loader struct {
 	descriptors          *model.Descriptors
 	images               *model.Images
 	defaultVolumeBaseDir string
-	substitutes          *Substitutes
+	warn                 log.Logger
 	debug                log.Logger
 }
 
 func NewLoader(descriptors *model.Descriptors, images *model.Images, defaultVolumeBaseDir string, warn, debug log.Logger) *Loader {
-	env := map[string]string{}
-	_, err := os.Stat(".env")
-	if err == nil {
-		readEnvFile(".env", env)
-	} else if !os.IsNotExist(err) {
-		debug.Printf("warn: cannot access .env file: %s", err)
-	}
-	for _, e := range os.Environ() {
-		s := strings.SplitN(e, "=", 2)
-		env[s[0]] = s[1]
-	}
-	return &Loader{descriptors, images, defaultVolumeBaseDir, NewSubstitutes(env, warn), debug}
+	return &Loader{descriptors, images, defaultVolumeBaseDir, warn, debug}
 }
 
 func (self *Loader) LoadPod(d *model.PodDescriptor) (pod *Pod, err error) {
+	return self.LoadPodServices(d, nil)
+}
+
+// Loads a pod containing only the provided services.
+// Volumes not mounted by any of the services are omitted.
+// If services is nil all services are loaded.
+func (self *Loader) LoadPodServices(d *model.PodDescriptor, services []string) (pod *Pod, err error) {
+	if pod, err = self.loadPod(d, services); err != nil {
+		return
+	}
+	pod.Networks, err = toNetworks(d, pod.Services, projectName(pod))
+	return
+}
+
+// Loads the pod without resolving its networks
+func (self *Loader) loadPod(d *model.PodDescriptor, services []string) (pod *Pod, err error) {
 	pod = &Pod{}
 	pod.File = d.File
-	pod.Name = self.effectiveString(d.Name)
-	hostname := self.effectiveString(d.Hostname)
-	domainname := self.effectiveString(d.Domainname)
+	pod.Name = d.Name
+	hostname := d.Hostname
+	domainname := d.Domainname
 	if hostname == "" {
 		hostname = pod.Name
 	}
@@ -55,15 +60,15 @@ func (self *Loader) LoadPod(d *model.PodDescriptor) (pod *Pod, err error) {
 	}
 	pod.Hostname = hostname
 	pod.Domainname = domainname
-	pod.Net = self.effectiveStringArray(d.Net)
-	pod.Dns = self.effectiveStringArray(d.Dns)
-	pod.DnsSearch = self.effectiveStringArray(d.DnsSearch)
-	pod.DisableHostsInjection, err = self.effectiveBool(d.DisableHostsInjection)
+	pod.Net = copyStringArray(d.Net)
+	pod.Dns = copyStringArray(d.Dns)
+	pod.DnsSearch = copyStringArray(d.DnsSearch)
+	pod.DisableHostsInjection, err = parseBool(d.DisableHostsInjection)
 	if err != nil {
 		return
 	}
-	pod.Environment = self.effectiveStringMap(d.Environment)
-	pod.Services, err = self.toServices(d)
+	pod.Environment = copyStringMap(d.Environment)
+	pod.Services, err = self.toServices(d, services)
 	if err != nil {
 		return
 	}
@@ -71,30 +76,51 @@ func (self *Loader) LoadPod(d *model.PodDescriptor) (pod *Pod, err error) {
 	if err != nil {
 		return
 	}
-	pod.SharedKeys = self.effectiveStringMap(d.SharedKeys)
-	pod.SharedKeysOverrideAllowed, err = self.effectiveBool(d.SharedKeysOverrideAllowed)
+	pod.SharedKeys = copyStringMap(d.SharedKeys)
+	pod.SharedKeysOverrideAllowed, err = parseBool(d.SharedKeysOverrideAllowed)
 	if err != nil {
 		return
 	}
-	pod.StopGracePeriod, err = self.effectiveDuration(d.StopGracePeriod, "10s")
+	pod.StopGracePeriod, err = parseDuration(d.StopGracePeriod, "10s")
 	if err != nil {
 		return
 	}
+	for _, s := range pod.Services {
+		// Remove dependencies that are not part of the pod
+		deps := []string{}
+		for _, dep := range s.DependsOn {
+			if pod.Services[dep] != nil {
+				deps = append(deps, dep)
+			}
+		}
+		s.DependsOn = deps
+	}
 	self.fileMountsToVolumes(pod)
+	if services != nil {
+		removeUnusedResources(pod, d)
+	}
 	self.addImageVolumes(pod)
 	return
 }
 
-func (self *Loader) toServices(d *model.PodDescriptor) (map[string]*Service, error) {
-	s := map[string]*Service{}
-	build := map[string]func() error{}
-	for k, v := range d.Services {
-		dest := NewService()
-		err := self.applyService(v, d, dest, build, map[string]bool{})
-		if err != nil {
-			return nil, err
+func (self *Loader) toServices(d *model.PodDescriptor, services []string) (map[string]*Service, error) {
+	s, build, err := self.resolveServices(d, services)
+	if err != nil {
+		return nil, err
+	}
+	// Fail fast if images must not be pulled but are missing
+	missing := []string{}
+	for _, k := range sortedServiceNames(s) {
+		v := s[k]
+		if _, ok := build[v.Image]; !ok && self.pullPolicy(v) == model.PULL_NEVER {
+			if _, err := self.images.FetchImage(v.Image, model.PULL_NEVER, v.Insecure); err != nil {
+				self.debug.Printf("Image %s of service %s not available: %s", v.Image, k, err)
+				missing = append(missing, fmt.Sprintf("%s (service %s)", v.Image, k))
+			}
 		}
-		s[k] = dest
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Missing images that must not be pulled:\n  %s", strings.Join(missing, "\n  "))
 	}
 	for _, v := range s {
 		var img *model.ImageMetadata
@@ -105,10 +131,11 @@ func (self *Loader) toServices(d *model.PodDescriptor) (map[string]*Service, err
 				return nil, err
 			}
 		}
-		img, err = self.images.Image(v.Image)
+		img, err = self.images.FetchImage(v.Image, self.pullPolicy(v), v.Insecure)
 		if err != nil {
 			return nil, err
 		}
+		v.ImageID = img.ID
 		// Assign properties derived from image
 		if len(v.Entrypoint) == 0 {
 			v.Entrypoint = []string{img.Exec[0]}
@@ -116,20 +143,203 @@ func (self *Loader) toServices(d *model.PodDescriptor) (map[string]*Service, err
 				v.Command = img.Exec[1:]
 			}
 		}
+		if v.User == "" {
+			v.User = img.User
+			if v.Group == "" {
+				v.Group = img.Group
+			}
+		}
+		if v.WorkingDir == "" {
+			v.WorkingDir = img.WorkingDirectory
+		}
+		for k, e := range img.Environment {
+			_, isPodEnv := d.Environment[k]
+			if _, ok := v.Environment[k]; !ok && !isPodEnv {
+				v.Environment[k] = e
+			}
+		}
+		if err = applyExposedPorts(v, img); err != nil {
+			return nil, err
+		}
 	}
 	return s, nil
 }
 
+// Derives the targets of ports published without target from the image's exposed ports
+func applyExposedPorts(s *Service, img *model.ImageMetadata) error {
+	for _, p := range s.Ports {
+		if p.Target == 0 {
+			exposed := img.ExposedPorts(p.Protocol)
+			if len(exposed) != 1 {
+				return fmt.Errorf("Cannot derive target of published port %d/%s since image %s exposes %d %s ports %v", p.Published, p.Protocol, img.Name, len(exposed), p.Protocol, exposed)
+			}
+			p.Target = exposed[0]
+		}
+	}
+	return nil
+}
+
+// Returns the service's pull policy or the default policy
+func (self *Loader) pullPolicy(s *Service) model.PullPolicy {
+	if p, _ := model.ToPullPolicy(s.PullPolicy); p != "" {
+		return p
+	}
+	return self.images.PullPolicy()
+}
+
+func sortedServiceNames(s map[string]*Service) []string {
+	r := make([]string, 0, len(s))
+	for k := range s {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Returns the services declared in the descriptor without image properties
+// and the functions to build their images
+func (self *Loader) resolveServices(d *model.PodDescriptor, services []string) (map[string]*Service, map[string]func() error, error) {
+	s := map[string]*Service{}
+	build := map[string]func() error{}
+	if services == nil {
+		for k := range d.Services {
+			services = append(services, k)
+		}
+	}
+	podGracePeriod, err := parseDuration(d.StopGracePeriod, "10s")
+	if err != nil {
+		return nil, nil, fmt.Errorf("stop_grace_period: %s", err)
+	}
+	for _, k := range services {
+		v := d.Services[k]
+		if v == nil {
+			return nil, nil, fmt.Errorf("Undefined service %q", k)
+		}
+		dest := NewService()
+		// Defaults to the pod's grace period unless declared by the service or the service it extends
+		dest.StopGracePeriod = podGracePeriod
+		err := self.applyService(v, d, dest, build, map[string]bool{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if dest.Init {
+			self.warn.Printf("Service %q: init is not supported and ignored. The pod's systemd reaps zombie processes", k)
+		}
+		_, isBuilt := build[dest.Image]
+		if !isBuilt && dest.PullPolicy == "build" {
+			return nil, nil, fmt.Errorf("Service %q has pull_policy build but no build section", k)
+		}
+		if !isBuilt {
+			if err = model.CheckImageVerifiable(dest.Image, dest.Insecure); err != nil {
+				return nil, nil, fmt.Errorf("Service %q: %s", k, err)
+			}
+		}
+		s[k] = dest
+	}
+	return s, build, nil
+}
+
+// Returns the sorted image names of all services declared in the descriptor.
+// Images are neither fetched nor built.
+func (self *Loader) ImageNames(d *model.PodDescriptor) ([]string, error) {
+	s, _, err := self.resolveServices(d, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := []string{}
+	added := map[string]bool{}
+	for _, k := range sortedServiceNames(s) {
+		if img := s[k].Image; !added[img] {
+			added[img] = true
+			r = append(r, img)
+		}
+	}
+	return r, nil
+}
+
+// Builds the images of the given services (all if nil) that declare a build.
+// Up to parallel images are built concurrently.
+func (self *Loader) BuildImages(d *model.PodDescriptor, services []string, parallel uint) error {
+	_, build, err := self.resolveServices(d, services)
+	if err != nil {
+		return err
+	}
+	tasks := make([]func() error, 0, len(build))
+	for _, b := range build {
+		tasks = append(tasks, b)
+	}
+	return runParallel(parallel, tasks)
+}
+
+// Fetches the images of the given services (all if nil) that do not declare a build.
+// Services with pull_policy never or build are skipped.
+// Up to parallel images are fetched concurrently.
+func (self *Loader) PullImages(d *model.PodDescriptor, services []string, parallel uint) error {
+	s, build, err := self.resolveServices(d, services)
+	if err != nil {
+		return err
+	}
+	tasks := []func() error{}
+	added := map[string]bool{}
+	for _, v := range s {
+		img := v.Image
+		insecure := v.Insecure
+		if v.PullPolicy == "never" || v.PullPolicy == "build" {
+			continue
+		}
+		if _, ok := build[img]; !ok && !added[img] {
+			added[img] = true
+			tasks = append(tasks, func() error {
+				_, err := self.images.FetchImage(img, "", insecure)
+				return err
+			})
+		}
+	}
+	return runParallel(parallel, tasks)
+}
+
+// Runs the tasks with the given max concurrency and returns their combined errors
+func runParallel(parallel uint, tasks []func() error) error {
+	if parallel == 0 {
+		parallel = 1
+	}
+	sem := make(chan bool, parallel)
+	errs := make([]error, len(tasks))
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+		sem <- true
+		go func(i int, task func() error) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = task()
+		}(i, task)
+	}
+	wg.Wait()
+	msgs := []string{}
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
 func (self *Loader) applyService(s *model.ServiceDescriptor, d *model.PodDescriptor, t *Service, build map[string]func() error, visited map[string]bool) error {
 	if s.Extends != nil {
-		baseServName := self.effectiveString(s.Extends.Service)
+		baseServName := s.Extends.Service
 		if baseServName == "" {
 			return fmt.Errorf(".extend.service is empty")
 		}
 		basePod := d
 		if s.Extends.File != "" {
 			var err error
-			basePod, err = self.descriptors.Descriptor(absPath(self.effectiveString(s.Extends.File), d.File))
+			basePod, err = self.descriptors.Descriptor(absPath(s.Extends.File, d.File))
 			if err != nil {
 				return fmt.Errorf("extends: %s", err)
 			}
@@ -154,35 +364,92 @@ func (self *Loader) applyService(s *model.ServiceDescriptor, d *model.PodDescrip
 		}
 	}
 	if s.Image != "" {
-		t.Image = self.effectiveString(s.Image)
+		t.Image = s.Image
 	}
 	if t.Image == "" && s.Build == nil {
 		return fmt.Errorf("service has no image")
 	}
-	if s.Build != nil {
-		buildCtx := absPath(self.effectiveString(s.Build.Context), d.File)
-		df := self.effectiveString(s.Build.Dockerfile)
-		if df == "" {
-			df = "Dockerfile"
+	if s.PullPolicy != "" {
+		if _, err := model.ToPullPolicy(s.PullPolicy); err != nil {
+			return err
 		}
-		df = path.Clean(absPath(df, buildCtx+"/"))
+		t.PullPolicy = s.PullPolicy
+	}
+	if err := applyBool(s.Insecure, &t.Insecure, "x-insecure"); err != nil {
+		return err
+	}
+	if s.Build != nil {
+		b := toBuildConfig(s.Build, d.File)
 		if t.Image == "" {
-			imgName, err := self.generateImageName(df)
+			imgName, err := self.generateImageName(b)
 			if err != nil {
 				return err
 			}
 			t.Image = imgName
 		}
 		build[t.Image] = func() error {
-			_, err := self.images.BuildImage(t.Image, df, buildCtx)
+			b.Force = t.PullPolicy == "build"
+			_, err := self.images.BuildImage(t.Image, b)
 			return err
 		}
 	}
 	if len(s.Entrypoint) > 0 {
-		t.Entrypoint = self.effectiveStringArray(s.Entrypoint)
+		t.Entrypoint = copyStringArray(s.Entrypoint)
 	}
 	if len(s.Command) > 0 {
-		t.Command = self.effectiveStringArray(s.Command)
+		t.Command = copyStringArray(s.Command)
+	}
+	if s.Pod != "" {
+		t.Pod = s.Pod
+	}
+	if s.WorkingDir != "" {
+		t.WorkingDir = s.WorkingDir
+	}
+	if s.User != "" {
+		// user[:group]
+		ug := strings.SplitN(s.User, ":", 2)
+		t.User = ug[0]
+		t.Group = ""
+		if len(ug) == 2 {
+			t.Group = ug[1]
+		}
+	}
+	if err := applyBool(s.Tty, &t.Tty, "tty"); err != nil {
+		return err
+	}
+	if err := applyBool(s.StdinOpen, &t.StdinOpen, "stdin_open"); err != nil {
+		return err
+	}
+	if err := applyBool(s.Init, &t.Init, "init"); err != nil {
+		return err
+	}
+	for k, v := range s.Networks {
+		if t.Networks == nil {
+			t.Networks = map[string]string{}
+		}
+		t.Networks[k] = ""
+		if v != nil {
+			t.Networks[k] = v.IPv4Address
+		}
+	}
+	for _, dep := range s.DependsOn {
+		if !containsString(t.DependsOn, dep) {
+			t.DependsOn = append(t.DependsOn, dep)
+		}
+	}
+	if s.StopSignal != "" {
+		sig, err := parseSignal(s.StopSignal)
+		if err != nil {
+			return fmt.Errorf("stop_signal: %s", err)
+		}
+		t.StopSignal = sig
+	}
+	if s.StopGracePeriod != "" {
+		gracePeriod, err := parseDuration(s.StopGracePeriod, "")
+		if err != nil {
+			return fmt.Errorf("stop_grace_period: %s", err)
+		}
+		t.StopGracePeriod = gracePeriod
 	}
 	err := self.toEnvironment(s, d.File, t.Environment)
 	if err != nil {
@@ -192,81 +459,79 @@ func (self *Loader) applyService(s *model.ServiceDescriptor, d *model.PodDescrip
 	if err != nil {
 		return err
 	}
-	for k, v := range self.effectiveStringMap(s.Mounts) {
+	for k, v := range copyStringMap(s.Mounts) {
 		t.Mounts[absPath(k, "/")] = absPath(v, d.File)
 	}
 	if err = self.toHealthCheck(s.HealthCheck, t.HealthCheck); err != nil {
 		return err
 	}
+	if err = self.toUnhealthyPolicy(s.OnUnhealthy, t.OnUnhealthy); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (self *Loader) generateImageName(df string) (string, error) {
-	st, err := os.Stat(df)
+func toBuildConfig(s *model.ServiceBuildDescriptor, podFile string) *model.BuildConfig {
+	b := &model.BuildConfig{}
+	b.Context = absPath(s.Context, podFile)
+	b.Dockerfile = s.Dockerfile
+	if b.Dockerfile == "" {
+		b.Dockerfile = "Dockerfile"
+	}
+	b.Dockerfile = path.Clean(absPath(b.Dockerfile, b.Context+"/"))
+	b.Args = copyStringMap(s.Args)
+	b.Target = s.Target
+	b.CacheFrom = copyStringArray(s.CacheFrom)
+	b.Labels = copyStringMap(s.Labels)
+	b.Network = s.Network
+	b.ShmSize = s.ShmSize
+	return b
+}
+
+// Derives the image name from the Dockerfile path and
+// tags it with a hash of the Dockerfile, build context and options
+func (self *Loader) generateImageName(b *model.BuildConfig) (string, error) {
+	hash, err := self.images.BuildHash(b)
 	if err != nil {
-		return "", fmt.Errorf("cannot access dockerfile %q: %s", df, err)
+		return "", err
 	}
-	tag := st.ModTime().Format("20060102150405")
-	return "local/" + toId(df) + ":" + tag, nil
+	return "local/" + toId(b.Dockerfile) + ":" + hash[:16], nil
 }
 
 func (self *Loader) toEnvironment(s *model.ServiceDescriptor, podFile string, e map[string]string) (err error) {
 	for _, f := range s.EnvFile {
-		err = readEnvFile(absPath(f, podFile), e)
+		err = self.descriptors.ReadEnvFile(absPath(f, podFile), e)
 		if err != nil {
 			return
 		}
 	}
 	for k, v := range s.Environment {
-		e[k] = self.effectiveString(v)
+		e[k] = v
 	}
 	return
 }
 
-func readEnvFile(file string, r map[string]string) error {
-	f, err := os.Open(filepath.FromSlash(file))
-	if err != nil {
-		return fmt.Errorf("cannot open env file %q: %s", file, err)
-	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
-	i := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" && strings.Index(line, "#") != 0 {
-			kv := strings.SplitN(line, "=", 2)
-			if len(kv) != 2 {
-				return fmt.Errorf("invalid env file entry at %s:%d: %q", file, i, kv)
-			}
-			r[kv[0]] = kv[1]
-		}
-		i++
-	}
-	if err = scanner.Err(); err != nil {
-		return fmt.Errorf("cannot read env file %q: %s", file, err)
-	}
-	return nil
-}
-
 func (self *Loader) toPorts(s []*model.PortBindingDescriptor, t []*PortBinding) ([]*PortBinding, error) {
 	var err error
+ports:
 	for _, e := range s {
 		p := &PortBinding{}
-		p.Target, err = self.effectiveUint16(e.Target)
+		p.Target, err = parseUint16(e.Target)
 		if err != nil {
 			return nil, fmt.Errorf("invalid target port: %s", err)
 		}
-		p.Published, err = self.effectiveUint16(e.Published)
+		p.Published, err = parseUint16(e.Published)
 		if err != nil {
 			return nil, fmt.Errorf("invalid published port: %s", err)
 		}
-		p.IP = self.effectiveString(e.IP)
-		p.Protocol = self.effectiveString(e.Protocol)
+		p.IP = e.IP
+		p.Protocol = e.Protocol
+		// Override inherited binding of the same port
 		for _, ex := range t {
-			if ex.Target == p.Target && ex.Target == p.Target {
+			if ex.Target == p.Target && ex.Protocol == p.Protocol {
 				ex.IP = p.IP
 				ex.Published = p.Published
-				return t, nil
+				continue ports
 			}
 		}
 		t = append(t, p)
@@ -278,27 +543,62 @@ func (self *Loader) toHealthCheck(s *model.HealthCheckDescriptor, t *HealthCheck
 	if s == nil {
 		return nil
 	}
-	t.Command = self.effectiveStringArray(s.Command)
-	t.Http = self.effectiveString(s.Http)
-	t.Interval, err = self.effectiveDuration(s.Interval, "30s")
+	t.Command = copyStringArray(s.Command)
+	t.Http = s.Http
+	t.Tcp, err = parseUint16(s.Tcp)
+	if err != nil {
+		return fmt.Errorf("invalid healthcheck tcp port: %s", err)
+	}
+	t.Grpc, err = parseUint16(s.Grpc)
+	if err != nil {
+		return fmt.Errorf("invalid healthcheck grpc port: %s", err)
+	}
+	t.GrpcService = s.GrpcService
+	t.Interval, err = parseDuration(s.Interval, "30s")
 	if err != nil {
 		return fmt.Errorf("invalid healthcheck interval: %s", err)
 	}
-	t.Timeout, err = self.effectiveDuration(s.Timeout, "20s")
+	t.Timeout, err = parseDuration(s.Timeout, "20s")
 	if err != nil {
 		return fmt.Errorf("invalid healthcheck timeout: %s", err)
 	}
-	t.Retries, err = self.effectiveUint(s.Retries)
+	t.StartPeriod, err = parseDuration(s.StartPeriod, "")
 	if err != nil {
+		return fmt.Errorf("invalid healthcheck start_period: %s", err)
+	}
+	if s.Retries == "" {
+		// Docker's default
+		t.Retries = 3
+	} else if t.Retries, err = parseUint(s.Retries); err != nil {
 		return fmt.Errorf("invalid healthcheck retries: %s", err)
 	}
-	t.Disable, err = self.effectiveBool(s.Disable)
+	t.Disable, err = parseBool(s.Disable)
 	if err != nil {
 		return fmt.Errorf("invalid healthcheck disable: %s", err)
 	}
 	return nil
 }
 
+func (self *Loader) toUnhealthyPolicy(s *model.UnhealthyPolicyDescriptor, t *UnhealthyPolicy) (err error) {
+	if s == nil {
+		return nil
+	}
+	action := UnhealthyAction(s.Action)
+	switch action {
+	case "":
+		action = UNHEALTHY_IGNORE
+	case UNHEALTHY_IGNORE, UNHEALTHY_RESTART, UNHEALTHY_STOP_POD:
+	default:
+		return fmt.Errorf("invalid on_unhealthy action %q. Expected %s, %s or %s", action, UNHEALTHY_RESTART, UNHEALTHY_STOP_POD, UNHEALTHY_IGNORE)
+	}
+	t.Action = action
+	t.Threshold, err = parseDuration(s.Threshold, "")
+	if err != nil {
+		return fmt.Errorf("invalid on_unhealthy threshold: %s", err)
+	}
+	return nil
+}
+
 func (self *Loader) toVolumes(d *model.PodDescriptor) (map[string]*Volume, error) {
 	r := map[string]*Volume{}
 	for k, v := range d.Volumes {
@@ -306,7 +606,7 @@ func (self *Loader) toVolumes(d *model.PodDescriptor) (map[string]*Volume, error
 		if kind == "" {
 			kind = "host"
 		}
-		ro, err := self.effectiveBool(v.Readonly)
+		ro, err := parseBool(v.Readonly)
 		if err != nil {
 			return nil, fmt.Errorf("invalid volume readonly value: %s", err)
 		}
@@ -318,7 +618,6 @@ func (self *Loader) toVolumes(d *model.PodDescriptor) (map[string]*Volume, error
 func (self *Loader) fileMountsToVolumes(pod *Pod) {
 	for _, s := range pod.Services {
 		for k, v := range s.Mounts {
-			v = self.effectiveString(v)
 			if isPath(v) {
 				volName := toId(relPath(v, pod.File))
 				s.Mounts[k] = volName
@@ -330,9 +629,30 @@ func (self *Loader) fileMountsToVolumes(pod *Pod) {
 	}
 }
 
+// Removes volumes and shared keys that refer to services not contained in the pod
+func removeUnusedResources(pod *Pod, d *model.PodDescriptor) {
+	used := map[string]bool{}
+	for _, s := range pod.Services {
+		for _, volName := range s.Mounts {
+			used[volName] = true
+		}
+	}
+	for k := range pod.Volumes {
+		if !used[k] {
+			delete(pod.Volumes, k)
+		}
+	}
+	for k, v := range pod.SharedKeys {
+		service := strings.SplitN(v, ":", 2)[0]
+		if d.Services[service] != nil && pod.Services[service] == nil {
+			delete(pod.SharedKeys, k)
+		}
+	}
+}
+
 func (self *Loader) addImageVolumes(pod *Pod) error {
 	for _, s := range pod.Services {
-		img, err := self.images.Image(s.Image)
+		img, err := self.images.FetchImage(s.Image, self.pullPolicy(s), s.Insecure)
 		if err != nil {
 			return err
 		}
@@ -346,44 +666,57 @@ func (self *Loader) addImageVolumes(pod *Pod) error {
 	return nil
 }
 
-func (self *Loader) effectiveString(v string) string {
-	return self.substitutes.Substitute(v)
-}
-
-func (self *Loader) effectiveStringArray(a []string) []string {
+func copyStringArray(a []string) []string {
 	r := make([]string, len(a))
-	for i, v := range a {
-		r[i] = self.effectiveString(v)
-	}
+	copy(r, a)
 	return r
 }
 
-func (self *Loader) effectiveStringMap(m map[string]string) map[string]string {
+func copyStringMap(m map[string]string) map[string]string {
 	r := make(map[string]string, len(m))
 	for k, v := range m {
-		r[k] = self.effectiveString(v)
+		r[k] = v
 	}
 	return r
 }
 
-func (self *Loader) effectiveBool(v model.BoolVal) (bool, error) {
+func parseBool(v model.BoolVal) (bool, error) {
 	s := string(v)
 	if s == "" {
 		return false, nil
 	}
-	return strconv.ParseBool(self.effectiveString(s))
+	return strconv.ParseBool(s)
 }
 
-func (self *Loader) effectiveUint16(v model.NumberVal) (uint16, error) {
-	d, err := self.effectiveUint(v)
+func containsString(a []string, s string) bool {
+	for _, e := range a {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets t to the parsed value if v is not empty
+func applyBool(v model.BoolVal, t *bool, name string) (err error) {
+	if v != "" {
+		if *t, err = parseBool(v); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func parseUint16(v model.NumberVal) (uint16, error) {
+	d, err := parseUint(v)
 	if err != nil || d > 65536 {
-		return 0, fmt.Errorf("invalid uint16: %q", self.effectiveString(string(v)))
+		return 0, fmt.Errorf("invalid uint16: %q", string(v))
 	}
 	return uint16(d), nil
 }
 
-func (self *Loader) effectiveUint(v model.NumberVal) (uint, error) {
-	s := self.effectiveString(string(v))
+func parseUint(v model.NumberVal) (uint, error) {
+	s := string(v)
 	if s == "" {
 		return 0, nil
 	}
@@ -394,8 +727,7 @@ func (self *Loader) effectiveUint(v model.NumberVal) (uint, error) {
 	return uint(d), nil
 }
 
-func (self *Loader) effectiveDuration(v, defaultVal string) (time.Duration, error) {
-	v = self.effectiveString(v)
+func parseDuration(v, defaultVal string) (time.Duration, error) {
 	if v == "" {
 		v = defaultVal
 	}
@@ -0,0 +1,122 @@
+package launcher
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/log"
+	"github.com/mgoltzsche/rkt-compose/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunParallel(t *testing.T) {
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	tasks := []func() error{}
+	for i := 0; i < 10; i++ {
+		i := i
+		tasks = append(tasks, func() error {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			if i%5 == 0 {
+				return fmt.Errorf("task %d failed", i)
+			}
+			return nil
+		})
+	}
+	err := runParallel(3, tasks)
+	if maxRunning != 3 {
+		t.Errorf("expected 3 concurrent tasks but was %d", maxRunning)
+	}
+	if err == nil || !strings.Contains(err.Error(), "task 0 failed") || !strings.Contains(err.Error(), "task 5 failed") {
+		t.Errorf("expected combined task errors but was %v", err)
+	}
+	if err = runParallel(0, tasks[1:2]); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestApplyExposedPorts(t *testing.T) {
+	img := model.NewImageMetadata("example", "sha512-1")
+	img.Ports["http"] = &model.ImagePort{Protocol: "tcp", Port: 80}
+	img.Ports["dns"] = &model.ImagePort{Protocol: "udp", Port: 53}
+	s := NewService()
+	s.Ports = []*PortBinding{{Target: 0, Published: 8080, Protocol: "tcp"}, {Target: 443, Published: 8443, Protocol: "tcp"}}
+	if err := applyExposedPorts(s, img); err != nil {
+		t.Fatal(err)
+	}
+	if s.Ports[0].Target != 80 || s.Ports[1].Target != 443 {
+		t.Errorf("expected targets 80 and 443 but were %d and %d", s.Ports[0].Target, s.Ports[1].Target)
+	}
+	img.Ports["https"] = &model.ImagePort{Protocol: "tcp", Port: 443}
+	s.Ports = []*PortBinding{{Target: 0, Published: 8080, Protocol: "tcp"}}
+	if err := applyExposedPorts(s, img); err == nil {
+		t.Errorf("should return error if image exposes multiple ports")
+	}
+}
+
+func TestToPorts(t *testing.T) {
+	inherited := []*PortBinding{{Target: 80, Published: 80, Protocol: "tcp"}, {Target: 53, Published: 53, Protocol: "udp"}}
+	ports, err := (&Loader{}).toPorts([]*model.PortBindingDescriptor{
+		{Target: "80", Published: "8080", Protocol: "tcp"},
+		{Target: "53", Published: "5353", Protocol: "tcp"},
+		{Target: "443", Published: "8443", Protocol: "tcp"},
+	}, inherited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, p := range ports {
+		actual = append(actual, fmt.Sprintf("%d:%d/%s", p.Published, p.Target, p.Protocol))
+	}
+	if s := strings.Join(actual, " "); s != "8080:80/tcp 53:53/udp 5353:53/tcp 8443:443/tcp" {
+		t.Errorf("ports should be overridden by target and protocol and appended otherwise but were %q", s)
+	}
+}
+
+func TestResolveServicesStopGracePeriod(t *testing.T) {
+	d := model.NewPodDescriptor()
+	d.StopGracePeriod = "20s"
+	d.Services["base"] = &model.ServiceDescriptor{Image: "example.org/app", StopGracePeriod: "5s"}
+	d.Services["ext"] = &model.ServiceDescriptor{Extends: &model.ServiceDescriptorExtension{Service: "base"}}
+	d.Services["override"] = &model.ServiceDescriptor{Extends: &model.ServiceDescriptorExtension{Service: "ext"}, StopGracePeriod: "0s"}
+	d.Services["plain"] = &model.ServiceDescriptor{Image: "example.org/app"}
+	loader := NewLoader(nil, nil, "", nil, nil)
+	s, _, err := loader.resolveServices(d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, expected := range map[string]string{"base": "5s", "ext": "5s", "override": "0s", "plain": "20s"} {
+		if actual := s[k].StopGracePeriod.String(); actual != expected {
+			t.Errorf("service %q: expected stop grace period %s but was %s", k, expected, actual)
+		}
+	}
+	d.Services["base"].Extends = &model.ServiceDescriptorExtension{Service: "override"}
+	if _, _, err = loader.resolveServices(d, []string{"ext"}); err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Errorf("should return circular extension error but returned %v", err)
+	}
+}
+
+func TestResolveServicesWarnsAboutInit(t *testing.T) {
+	d := model.NewPodDescriptor()
+	d.Services["base"] = &model.ServiceDescriptor{Image: "example.org/app", Init: "true"}
+	d.Services["ext"] = &model.ServiceDescriptor{Extends: &model.ServiceDescriptorExtension{Service: "base"}}
+	var warnings bytes.Buffer
+	loader := NewLoader(nil, nil, "", log.NewStdLogger(&warnings), nil)
+	if _, _, err := loader.resolveServices(d, []string{"ext"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warnings.String(), `Service "ext": init is not supported`) {
+		t.Errorf("inherited init should be warned about but warnings were %q", warnings.String())
+	}
+}
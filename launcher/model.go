@@ -7,30 +7,51 @@ import (
 )
 
 type Pod struct {
-	File                      string              `json:"-"`
-	Name                      string              `json:"name"`
-	Net                       []string            `json:"net"`
-	Dns                       []string            `json:"dns"`
-	DnsSearch                 []string            `json:"dns_search"`
-	Hostname                  string              `json:"hostname"`
-	Domainname                string              `json:"domainname"`
-	DisableHostsInjection     bool                `json:"disable_hosts_injection"`
-	Environment               map[string]string   `json:"environment"`
-	Services                  map[string]*Service `json:"services"`
-	Volumes                   map[string]*Volume  `json:"volumes"`
-	SharedKeys                map[string]string   `json:"shared_keys"`
-	SharedKeysOverrideAllowed bool                `json:"shared_keys_overridable"`
-	StopGracePeriod           time.Duration       `json:"stop_grace_period"`
+	File                  string              `json:"-"`
+	Name                  string              `json:"name"`
+	Net                   []string            `json:"net"`
+	Dns                   []string            `json:"dns"`
+	DnsSearch             []string            `json:"dns_search"`
+	Hostname              string              `json:"hostname"`
+	Domainname            string              `json:"domainname"`
+	DisableHostsInjection bool                `json:"disable_hosts_injection"`
+	Environment           map[string]string   `json:"environment"`
+	Services              map[string]*Service `json:"services"`
+	Volumes               map[string]*Volume  `json:"volumes"`
+	Networks              map[string]*Network `json:"networks,omitempty"`
+	// Additional /etc/hosts entries (e.g. of the project's other pods)
+	ExtraHosts                []*HostsEntry     `json:"extra_hosts,omitempty"`
+	SharedKeys                map[string]string `json:"shared_keys"`
+	SharedKeysOverrideAllowed bool              `json:"shared_keys_overridable"`
+	StopGracePeriod           time.Duration     `json:"stop_grace_period"`
 }
 
 type Service struct {
-	Image       string                 `json:"image"`
-	Entrypoint  []string               `json:"entrypoint"`
-	Command     []string               `json:"command"`
-	Environment map[string]string      `json:"environment"`
-	HealthCheck *HealthCheckDescriptor `json:"healthcheck"`
-	Ports       []*PortBinding         `json:"ports"`
-	Mounts      map[string]string      `json:"mounts"`
+	Image      string   `json:"image"`
+	ImageID    string   `json:"image_id,omitempty"`
+	PullPolicy string   `json:"pull_policy,omitempty"`
+	Insecure   bool     `json:"insecure,omitempty"`
+	Entrypoint []string `json:"entrypoint"`
+	Command    []string `json:"command"`
+	WorkingDir string   `json:"working_dir,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Group      string   `json:"group,omitempty"`
+	Tty        bool     `json:"tty,omitempty"`
+	StdinOpen  bool     `json:"stdin_open,omitempty"`
+	Init       bool     `json:"init,omitempty"`
+	StopSignal string   `json:"stop_signal,omitempty"`
+	// Defaults to the pod's stop grace period
+	StopGracePeriod time.Duration          `json:"stop_grace_period"`
+	DependsOn       []string               `json:"depends_on,omitempty"`
+	Environment     map[string]string      `json:"environment"`
+	HealthCheck     *HealthCheckDescriptor `json:"healthcheck"`
+	OnUnhealthy     *UnhealthyPolicy       `json:"on_unhealthy"`
+	Ports           []*PortBinding         `json:"ports"`
+	Mounts          map[string]string      `json:"mounts"`
+	// Static IPv4 address (or empty) by network
+	Networks map[string]string `json:"networks,omitempty"`
+	// Pod the service is run in when run as project
+	Pod string `json:"pod,omitempty"`
 }
 
 func NewService() *Service {
@@ -40,7 +61,8 @@ func NewService() *Service {
 	r.Environment = map[string]string{}
 	r.Ports = []*PortBinding{}
 	r.Mounts = map[string]string{}
-	r.HealthCheck = &HealthCheckDescriptor{nil, "", time.Duration(10), time.Duration(10), 0, true}
+	r.HealthCheck = &HealthCheckDescriptor{nil, "", 0, 0, "", time.Duration(10), time.Duration(10), 0, 3, true}
+	r.OnUnhealthy = &UnhealthyPolicy{UNHEALTHY_IGNORE, 0}
 	return r
 }
 
@@ -57,13 +79,47 @@ type Volume struct {
 	Readonly bool   `json:"readonly"`
 }
 
+type Network struct {
+	// CNI network name
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	DriverOpts map[string]string `json:"driver_opts,omitempty"`
+	Subnet     string            `json:"subnet,omitempty"`
+	Gateway    string            `json:"gateway,omitempty"`
+	External   bool              `json:"external,omitempty"`
+	// Static IPv4 address of the pod within the network
+	IP string `json:"ip,omitempty"`
+}
+
+type HostsEntry struct {
+	IP    string   `json:"ip"`
+	Names []string `json:"names"`
+}
+
 type HealthCheckDescriptor struct {
-	Command  []string      `json:"cmd"`
-	Http     string        `json:"http"`
-	Interval time.Duration `json:"interval"`
-	Timeout  time.Duration `json:"timeout"`
-	Retries  uint          `json:"retries"`
-	Disable  bool          `json:"disable"`
+	Command     []string      `json:"cmd"`
+	Http        string        `json:"http"`
+	Tcp         uint16        `json:"tcp"`
+	Grpc        uint16        `json:"grpc"`
+	GrpcService string        `json:"grpc_service"`
+	Interval    time.Duration `json:"interval"`
+	Timeout     time.Duration `json:"timeout"`
+	StartPeriod time.Duration `json:"start_period"`
+	Retries     uint          `json:"retries"`
+	Disable     bool          `json:"disable"`
+}
+
+type UnhealthyAction string
+
+const (
+	UNHEALTHY_IGNORE   UnhealthyAction = "ignore"
+	UNHEALTHY_RESTART  UnhealthyAction = "restart"
+	UNHEALTHY_STOP_POD UnhealthyAction = "stop-pod"
+)
+
+type UnhealthyPolicy struct {
+	Action    UnhealthyAction `json:"action"`
+	Threshold time.Duration   `json:"threshold"`
 }
 
 func (d *Pod) JSON() string {
@@ -0,0 +1,300 @@
+package launcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/model"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Prefix of the generated rkt user config directories containing network configs
+const RKT_NET_CONFIG_DIR_PREFIX = "rkt-compose-net-"
+
+// CNI network config
+type cniNetConf struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Bridge    string   `json:"bridge,omitempty"`
+	Master    string   `json:"master,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	IsGateway bool     `json:"isGateway,omitempty"`
+	IPMasq    bool     `json:"ipMasq,omitempty"`
+	IPAM      *cniIPAM `json:"ipam"`
+}
+
+type cniIPAM struct {
+	Type    string      `json:"type"`
+	Subnet  string      `json:"subnet"`
+	Gateway string      `json:"gateway,omitempty"`
+	Routes  []*cniRoute `json:"routes,omitempty"`
+}
+
+type cniRoute struct {
+	Dst string `json:"dst"`
+}
+
+// Returns the networks the pod's services are attached to
+func toNetworks(d *model.PodDescriptor, services map[string]*Service, project string) (map[string]*Network, error) {
+	r := map[string]*Network{}
+	for _, k := range sortedServiceNames(services) {
+		for netName, ip := range services[k].Networks {
+			n := r[netName]
+			if n == nil {
+				nd := d.Networks[netName]
+				if nd == nil {
+					return nil, fmt.Errorf("service %q: undefined network %q", k, netName)
+				}
+				var err error
+				if n, err = toNetwork(netName, nd, project); err != nil {
+					return nil, fmt.Errorf("network %q: %s", netName, err)
+				}
+				r[netName] = n
+			}
+			if ip == "" {
+				continue
+			}
+			if n.IP != "" && n.IP != ip {
+				// All apps share the pod's network namespace
+				return nil, fmt.Errorf("service %q: ipv4_address %s of network %q conflicts with %s of another service", k, ip, netName, n.IP)
+			}
+			if err := n.validateIP(ip); err != nil {
+				return nil, fmt.Errorf("service %q: network %q: %s", k, netName, err)
+			}
+			n.IP = ip
+		}
+	}
+	return r, nil
+}
+
+func toNetwork(key string, d *model.NetworkDescriptor, project string) (n *Network, err error) {
+	n = &Network{Name: d.Name, Driver: d.Driver, Subnet: d.Subnet, Gateway: d.Gateway}
+	n.DriverOpts = copyStringMap(d.DriverOpts)
+	if n.External, err = parseBool(d.External); err != nil {
+		return nil, fmt.Errorf("invalid external value: %s", err)
+	}
+	if n.Driver == "" {
+		n.Driver = "bridge"
+	}
+	if n.Name == "" {
+		if n.External {
+			n.Name = key
+		} else {
+			n.Name = project + "-" + toId(key)
+		}
+	}
+	switch n.Driver {
+	case "host", "none":
+		n.Name = n.Driver
+		return
+	case "bridge":
+	case "macvlan", "ipvlan":
+		if !n.External && n.DriverOpts["parent"] == "" {
+			return nil, fmt.Errorf("driver_opts.parent required by %s driver", n.Driver)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported driver %q", n.Driver)
+	}
+	if n.External {
+		return
+	}
+	if n.Subnet == "" {
+		return nil, fmt.Errorf("ipam subnet required")
+	}
+	if _, _, err = net.ParseCIDR(n.Subnet); err != nil {
+		return nil, fmt.Errorf("invalid subnet: %s", err)
+	}
+	if n.Gateway != "" {
+		if err = n.validateIP(n.Gateway); err != nil {
+			return nil, fmt.Errorf("invalid gateway: %s", err)
+		}
+	}
+	return
+}
+
+// Returns an error if the IP is invalid or not within the network's subnet
+func (n *Network) validateIP(ip string) error {
+	if n.Driver == "host" || n.Driver == "none" {
+		return fmt.Errorf("static IP not supported by %s network", n.Driver)
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return fmt.Errorf("invalid IPv4 address %q", ip)
+	}
+	if n.Subnet != "" {
+		if _, subnet, err := net.ParseCIDR(n.Subnet); err == nil && !subnet.Contains(addr) {
+			return fmt.Errorf("IP %s is not within subnet %s", ip, n.Subnet)
+		}
+	}
+	return nil
+}
+
+// Returns the pod's name or the name of the directory containing the pod file
+func projectName(pod *Pod) string {
+	name := pod.Name
+	if name == "" {
+		abs, err := filepath.Abs(pod.File)
+		if err == nil {
+			name = filepath.Base(filepath.Dir(abs))
+		}
+	}
+	return toId(name)
+}
+
+// Returns the CNI config of a network that is not external
+func (n *Network) cniNetConf() *cniNetConf {
+	r := &cniNetConf{Name: n.Name, Type: n.Driver}
+	r.IPAM = &cniIPAM{Type: "host-local", Subnet: n.Subnet, Gateway: n.Gateway}
+	switch n.Driver {
+	case "bridge":
+		r.Bridge = n.DriverOpts["com.docker.network.bridge.name"]
+		if r.Bridge == "" {
+			// Interface names must not exceed 15 characters
+			h := sha256.Sum256([]byte(n.Name))
+			r.Bridge = "rc-" + hex.EncodeToString(h[:])[:12]
+		}
+		r.IsGateway = true
+		r.IPMasq = true
+	case "macvlan":
+		r.Master = n.DriverOpts["parent"]
+		r.Mode = n.DriverOpts["macvlan_mode"]
+	case "ipvlan":
+		r.Master = n.DriverOpts["parent"]
+		r.Mode = n.DriverOpts["ipvlan_mode"]
+	}
+	if r.IsGateway || n.Gateway != "" {
+		r.IPAM.Routes = []*cniRoute{{"0.0.0.0/0"}}
+	}
+	return r
+}
+
+// Returns the --net args attaching the pod to its networks
+func netArgs(networks map[string]*Network) []string {
+	names := make([]string, 0, len(networks))
+	for k := range networks {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	r := []string{}
+	for _, k := range names {
+		n := networks[k]
+		a := "--net=" + n.Name
+		if n.IP != "" {
+			a += ":IP=" + n.IP
+		}
+		r = append(r, a)
+	}
+	return r
+}
+
+// Writes the CNI configs of the networks declared by the pod into the net.d
+// directory of a new rkt user config directory within baseDir.
+// Returns the directory or an empty string if the pod declares no network.
+// The directory is kept as long as the pod exists since rkt needs the network
+// configs to tear the pod's networks down on gc (see gcRktNetworkConfigs).
+func writeRktNetworkConfig(baseDir string, networks map[string]*Network) (dir string, err error) {
+	names := make([]string, 0, len(networks))
+	for k, n := range networks {
+		if !n.External && n.Driver != "host" && n.Driver != "none" {
+			names = append(names, k)
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	if baseDir == "" {
+		baseDir = os.TempDir()
+	} else if err = ensureOwnedDir(baseDir); err != nil {
+		return "", err
+	}
+	// Creates the directory with a random name and mode 0700
+	if dir, err = ioutil.TempDir(baseDir, RKT_NET_CONFIG_DIR_PREFIX); err != nil {
+		return "", fmt.Errorf("Cannot create rkt network config dir: %s", err)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+			dir = ""
+		}
+	}()
+	netDir := filepath.Join(dir, "net.d")
+	if err = os.Mkdir(netDir, 0700); err != nil {
+		return "", fmt.Errorf("Cannot create rkt network config dir: %s", err)
+	}
+	for _, k := range names {
+		n := networks[k]
+		b, err := json.MarshalIndent(n.cniNetConf(), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		if err = ioutil.WriteFile(filepath.Join(netDir, "50-"+n.Name+".conf"), b, 0600); err != nil {
+			return "", fmt.Errorf("Cannot write rkt network config: %s", err)
+		}
+	}
+	return dir, nil
+}
+
+// Creates the directory if it does not exist and returns an error if it is
+// not a directory owned by the current (root) user
+func ensureOwnedDir(dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("Cannot create %s: %s", filepath.Dir(dir), err)
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("Cannot create %s: %s", dir, err)
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !isOwnedDir(fi) {
+		return fmt.Errorf("Refusing to use %s since it is not a directory owned by uid %d", dir, os.Geteuid())
+	}
+	return nil
+}
+
+func isOwnedDir(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return fi.IsDir() && ok && int(st.Uid) == os.Geteuid()
+}
+
+// Removes the network config directories within baseDir whose pod has been
+// removed. Directories without pod (since preparing it failed) are removed
+// when they are older than an hour.
+func gcRktNetworkConfigs(baseDir string, podExists func(uuid string) bool) {
+	if baseDir == "" {
+		baseDir = os.TempDir()
+	}
+	files, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), RKT_NET_CONFIG_DIR_PREFIX) || !isOwnedDir(f) {
+			continue
+		}
+		dir := filepath.Join(baseDir, f.Name())
+		b, err := ioutil.ReadFile(filepath.Join(dir, "pod-uuid"))
+		if err != nil && time.Since(f.ModTime()) < time.Hour {
+			continue
+		}
+		if err == nil && podExists(strings.TrimSpace(string(b))) {
+			continue
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// Records the pod the network config directory belongs to
+func writeRktNetworkConfigOwner(dir, podUUID string) error {
+	return ioutil.WriteFile(filepath.Join(dir, "pod-uuid"), []byte(podUUID), 0600)
+}
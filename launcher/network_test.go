@@ -0,0 +1,109 @@
+package launcher
+
+import (
+	"github.com/mgoltzsche/rkt-compose/model"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestToNetworks(t *testing.T) {
+	d := model.NewPodDescriptor()
+	d.Networks["frontend"] = &model.NetworkDescriptor{Subnet: "10.5.0.0/24", Gateway: "10.5.0.1"}
+	d.Networks["backend"] = &model.NetworkDescriptor{Driver: "macvlan", DriverOpts: map[string]string{"parent": "eth0"}, Subnet: "10.6.0.0/24"}
+	d.Networks["shared"] = &model.NetworkDescriptor{External: "true"}
+	d.Networks["unused"] = &model.NetworkDescriptor{Subnet: "10.7.0.0/24"}
+	pod := &Pod{Name: "My App", Services: map[string]*Service{
+		"web": &Service{Networks: map[string]string{"frontend": "10.5.0.10", "backend": ""}},
+		"db":  &Service{Networks: map[string]string{"backend": "", "shared": ""}},
+	}}
+	networks, err := toNetworks(d, pod.Services, projectName(pod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]*Network{
+		"frontend": &Network{Name: "my-app-frontend", Driver: "bridge", DriverOpts: map[string]string{}, Subnet: "10.5.0.0/24", Gateway: "10.5.0.1", IP: "10.5.0.10"},
+		"backend":  &Network{Name: "my-app-backend", Driver: "macvlan", DriverOpts: map[string]string{"parent": "eth0"}, Subnet: "10.6.0.0/24"},
+		"shared":   &Network{Name: "shared", Driver: "bridge", DriverOpts: map[string]string{}, External: true},
+	}
+	if !reflect.DeepEqual(networks, expected) {
+		t.Errorf("toNetworks() returned unexpected networks: %+v", networks)
+	}
+	expectedArgs := []string{"--net=my-app-backend", "--net=my-app-frontend:IP=10.5.0.10", "--net=shared"}
+	if args := netArgs(networks); !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("netArgs() should return %v but returned %v", expectedArgs, args)
+	}
+	for _, ip := range []string{"10.5.1.10", "10.5.0.11", "invalid"} {
+		pod.Services["db"].Networks["frontend"] = ip
+		if _, err = toNetworks(d, pod.Services, "my-app"); err == nil {
+			t.Errorf("toNetworks() should return error for ipv4_address %s", ip)
+		}
+	}
+	pod.Services["db"].Networks = map[string]string{"undefined": ""}
+	if _, err = toNetworks(d, pod.Services, "my-app"); err == nil {
+		t.Errorf("toNetworks() should return error for undefined network")
+	}
+}
+
+func TestWriteRktNetworkConfig(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "rkt-compose-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+	n := &Network{Name: "my-app-frontend", Driver: "bridge", Subnet: "10.5.0.0/24"}
+	conf := n.cniNetConf()
+	if len(conf.Bridge) > 15 || conf.IPAM.Type != "host-local" || len(conf.IPAM.Routes) != 1 {
+		t.Errorf("unexpected bridge network config: %+v", conf)
+	}
+	networks := map[string]*Network{"frontend": n, "shared": &Network{Name: "shared", Driver: "bridge", External: true}}
+	netBaseDir := filepath.Join(baseDir, ".net")
+	dir, err := writeRktNetworkConfig(netBaseDir, networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("network config dir should have mode 0700")
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "net.d"))
+	if err != nil || len(files) != 1 || files[0].Name() != "50-my-app-frontend.conf" {
+		t.Errorf("net.d should contain only the generated network's config")
+	}
+	if other, err := writeRktNetworkConfig(netBaseDir, networks); err != nil || other == dir {
+		t.Errorf("each pod should get its own network config dir: %s, %v", other, err)
+	}
+	if err = writeRktNetworkConfigOwner(dir, "existing-pod"); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := writeRktNetworkConfig(netBaseDir, networks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeRktNetworkConfigOwner(removed, "removed-pod"); err != nil {
+		t.Fatal(err)
+	}
+	gcRktNetworkConfigs(netBaseDir, func(uuid string) bool { return uuid == "existing-pod" })
+	if _, err = os.Stat(removed); !os.IsNotExist(err) {
+		t.Errorf("network config of removed pod should be removed")
+	}
+	if _, err = os.Stat(dir); err != nil {
+		t.Errorf("network config of existing pod should be kept")
+	}
+	if dir, err = writeRktNetworkConfig(netBaseDir, map[string]*Network{"shared": networks["shared"]}); err != nil || dir != "" {
+		t.Errorf("no network config dir should be written for external networks")
+	}
+	if os.Geteuid() == 0 {
+		foreign := filepath.Join(baseDir, "foreign")
+		if err = os.Mkdir(foreign, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chown(foreign, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = writeRktNetworkConfig(foreign, networks); err == nil {
+			t.Errorf("writeRktNetworkConfig() should refuse directory not owned by root")
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package launcher
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/model"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Network all pods of a project are attached to
+const PROJECT_NETWORK = "default"
+
+// Number of /24 subnets a project network is allocated from (10.100-199.x.0)
+const SUBNET_COUNT = 100 * 256
+
+// Loads the services as project: each group of services sharing the same
+// pod (x-pod or the service name by default) is loaded as a separate pod.
+// The pods are attached to the project network with static IPs and resolve
+// each other's services using /etc/hosts entries.
+// Returns the pods in start order.
+func (self *Loader) LoadProject(d *model.PodDescriptor, services []string) ([]*Pod, error) {
+	all, err := self.loadPod(d, services)
+	if err != nil {
+		return nil, err
+	}
+	project := projectName(all)
+	projectNet, err := projectNetwork(d, project, hostNetworks())
+	if err != nil {
+		return nil, err
+	}
+	groups := map[string][]string{}
+	groupOf := map[string]string{}
+	for _, k := range sortedServiceNames(all.Services) {
+		g := all.Services[k].Pod
+		if g == "" {
+			g = k
+		}
+		groups[g] = append(groups[g], k)
+		groupOf[k] = g
+	}
+	groupNames := make([]string, 0, len(groups))
+	for g := range groups {
+		groupNames = append(groupNames, g)
+	}
+	sort.Strings(groupNames)
+	pods := map[string]*Pod{}
+	deps := map[string][]string{}
+	used := map[string]bool{}
+	for _, g := range groupNames {
+		pod, err := self.subPod(d, all, project, g, groups[g])
+		if err != nil {
+			return nil, fmt.Errorf("pod %q: %s", g, err)
+		}
+		pods[g] = pod
+		if n := pod.Networks[PROJECT_NETWORK]; n != nil && n.IP != "" {
+			if used[n.IP] {
+				return nil, fmt.Errorf("pod %q: ipv4_address %s of network %q is used by another pod", g, n.IP, PROJECT_NETWORK)
+			}
+			used[n.IP] = true
+		}
+		deps[g] = []string{}
+		for _, k := range groups[g] {
+			for _, dep := range all.Services[k].DependsOn {
+				if dg := groupOf[dep]; dg != g && !containsString(deps[g], dg) {
+					deps[g] = append(deps[g], dg)
+				}
+			}
+		}
+	}
+	// Attach the pods to the project network
+	for _, g := range groupNames {
+		pod := pods[g]
+		if n := pod.Networks[PROJECT_NETWORK]; n != nil && n.IP != "" {
+			continue
+		}
+		n := *projectNet
+		if n.IP, err = n.nextIP(used); err != nil {
+			return nil, fmt.Errorf("network %q: %s", PROJECT_NETWORK, err)
+		}
+		pod.Networks[PROJECT_NETWORK] = &n
+	}
+	// Let the pods resolve each other's services
+	for _, g := range groupNames {
+		for _, other := range groupNames {
+			if other != g {
+				names := []string{other}
+				for _, k := range groups[other] {
+					if k != other {
+						names = append(names, k)
+					}
+				}
+				pods[g].ExtraHosts = append(pods[g].ExtraHosts, &HostsEntry{pods[other].Networks[PROJECT_NETWORK].IP, names})
+			}
+		}
+	}
+	// Start pods before those depending on them
+	r := make([]*Pod, 0, len(pods))
+	waves := dependencyWaves(deps)
+	for i := len(waves) - 1; i >= 0; i-- {
+		for _, g := range waves[i] {
+			r = append(r, pods[g])
+		}
+	}
+	return r, nil
+}
+
+// Returns a pod containing only the provided services of the project's pod
+func (self *Loader) subPod(d *model.PodDescriptor, all *Pod, project, group string, services []string) (*Pod, error) {
+	pod := *all
+	pod.Name = project + "-" + group
+	pod.Hostname = group
+	pod.Services = map[string]*Service{}
+	for _, k := range services {
+		s := *all.Services[k]
+		// Dependencies to other pods are resolved by the project's start order
+		s.DependsOn = []string{}
+		for _, dep := range all.Services[k].DependsOn {
+			if containsString(services, dep) {
+				s.DependsOn = append(s.DependsOn, dep)
+			}
+		}
+		pod.Services[k] = &s
+	}
+	pod.Volumes = map[string]*Volume{}
+	for k, v := range all.Volumes {
+		pod.Volumes[k] = v
+	}
+	pod.SharedKeys = copyStringMap(all.SharedKeys)
+	removeUnusedResources(&pod, d)
+	if err := self.addImageVolumes(&pod); err != nil {
+		return nil, err
+	}
+	var err error
+	pod.Networks, err = toNetworks(d, pod.Services, project)
+	return &pod, err
+}
+
+// Returns the project network declared as "default" or a bridge network
+// with a /24 subnet between 10.100.0.0 and 10.199.255.0 that does not overlap
+// the used networks. The search starts at a subnet derived from the project
+// name so that a project gets the same subnet on each start.
+func projectNetwork(d *model.PodDescriptor, project string, used []*net.IPNet) (*Network, error) {
+	nd := d.Networks[PROJECT_NETWORK]
+	if nd == nil {
+		h := sha256.Sum256([]byte(project))
+		start := int(binary.BigEndian.Uint16(h[:2])) % SUBNET_COUNT
+		for i := 0; i < SUBNET_COUNT && nd == nil; i++ {
+			n := (start + i) % SUBNET_COUNT
+			subnet := &net.IPNet{IP: net.IPv4(10, byte(100+n/256), byte(n%256), 0).To4(), Mask: net.CIDRMask(24, 32)}
+			if !overlapsAny(subnet, used) {
+				nd = &model.NetworkDescriptor{Subnet: subnet.String()}
+			}
+		}
+		if nd == nil {
+			return nil, fmt.Errorf("network %q: no free subnet left. Declare the network's subnet explicitly", PROJECT_NETWORK)
+		}
+	}
+	n, err := toNetwork(PROJECT_NETWORK, nd, project)
+	if err != nil {
+		return nil, fmt.Errorf("network %q: %s", PROJECT_NETWORK, err)
+	}
+	if n.Driver == "host" || n.Driver == "none" || n.Subnet == "" {
+		return nil, fmt.Errorf("network %q: project network requires an IPv4 subnet", PROJECT_NETWORK)
+	}
+	return n, nil
+}
+
+func overlapsAny(subnet *net.IPNet, networks []*net.IPNet) bool {
+	for _, n := range networks {
+		if n.Contains(subnet.IP) || subnet.Contains(n.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the networks of the host's interface addresses and routes
+// except the default route
+func hostNetworks() []*net.IPNet {
+	r := []*net.IPNet{}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+				r = append(r, &net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask})
+			}
+		}
+	}
+	if b, err := ioutil.ReadFile("/proc/net/route"); err == nil {
+		r = append(r, parseRoutes(string(b))...)
+	}
+	return r
+}
+
+// Parses the destinations of the routes listed in /proc/net/route
+func parseRoutes(table string) []*net.IPNet {
+	r := []*net.IPNet{}
+	for i, line := range strings.Split(table, "\n") {
+		f := strings.Fields(line)
+		if i == 0 || len(f) < 8 {
+			continue
+		}
+		dst, err1 := strconv.ParseUint(f[1], 16, 32)
+		mask, err2 := strconv.ParseUint(f[7], 16, 32)
+		if err1 != nil || err2 != nil || mask == 0 {
+			continue
+		}
+		// Addresses are listed in host (little endian) byte order
+		n := &net.IPNet{IP: make(net.IP, 4), Mask: make(net.IPMask, 4)}
+		binary.LittleEndian.PutUint32(n.IP, uint32(dst))
+		binary.LittleEndian.PutUint32(n.Mask, uint32(mask))
+		r = append(r, n)
+	}
+	return r
+}
+
+// Returns the first IP within the network's subnet that is neither used
+// nor the network's address, its gateway (the 1st IP by default) or broadcast address
+func (n *Network) nextIP(used map[string]bool) (string, error) {
+	_, subnet, err := net.ParseCIDR(n.Subnet)
+	if err != nil || subnet.IP.To4() == nil {
+		return "", fmt.Errorf("IPv4 subnet expected but was %q", n.Subnet)
+	}
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	ones, bits := subnet.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+	for i := uint64(2); i+1 < size; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+uint32(i))
+		s := ip.String()
+		if !used[s] && s != n.Gateway {
+			used[s] = true
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("no free IP left within subnet %s", n.Subnet)
+}
+
+// Runs the pods of a project as one unit: the pods are started in the
+// provided order and stopped in reverse order.
+// When one pod terminates all other pods are stopped as well.
+type ProjectLauncher struct {
+	pods    []*PodLauncher
+	stopped bool
+	mutex   *sync.Mutex
+}
+
+func NewProjectLauncher(pods []*PodLauncher) *ProjectLauncher {
+	return &ProjectLauncher{pods, false, &sync.Mutex{}}
+}
+
+func (p *ProjectLauncher) Run() (err error) {
+	started := 0
+	for _, l := range p.pods {
+		p.mutex.Lock()
+		stopped := p.stopped
+		p.mutex.Unlock()
+		if stopped {
+			break
+		}
+		if err = l.Start(); err != nil {
+			if e := p.Stop(); e != nil {
+				err = fmt.Errorf("%s. stop: %s", err, e)
+			}
+			return
+		}
+		started++
+	}
+	if started == 0 {
+		return
+	}
+	done := make(chan error, started)
+	for _, l := range p.pods[:started] {
+		go func(l *PodLauncher) {
+			done <- l.Supervise()
+		}(l)
+	}
+	err = <-done
+	if e := p.Stop(); e != nil && err == nil {
+		err = e
+	}
+	for i := 1; i < started; i++ {
+		if e := <-done; e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Stops the pods in reverse start order
+func (p *ProjectLauncher) Stop() error {
+	p.mutex.Lock()
+	p.stopped = true
+	p.mutex.Unlock()
+	msgs := []string{}
+	for i := len(p.pods) - 1; i >= 0; i-- {
+		if err := p.pods[i].Stop(); err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+func (p *ProjectLauncher) MarkGarbageContainersQuiet() {
+	if len(p.pods) > 0 {
+		p.pods[0].MarkGarbageContainersQuiet()
+	}
+}
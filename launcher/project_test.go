@@ -0,0 +1,78 @@
+package launcher
+
+import (
+	"github.com/mgoltzsche/rkt-compose/model"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNextIP(t *testing.T) {
+	n := &Network{Driver: "bridge", Subnet: "10.5.0.0/29", Gateway: "10.5.0.3"}
+	used := map[string]bool{"10.5.0.4": true}
+	for _, expected := range []string{"10.5.0.2", "10.5.0.5", "10.5.0.6"} {
+		ip, err := n.nextIP(used)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip != expected {
+			t.Errorf("nextIP() should return %s but returned %s", expected, ip)
+		}
+	}
+	if ip, err := n.nextIP(used); err == nil {
+		t.Errorf("nextIP() should return error when subnet is exhausted but returned %s", ip)
+	}
+}
+
+func TestProjectNetwork(t *testing.T) {
+	d := model.NewPodDescriptor()
+	n, err := projectNetwork(d, "myproject", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Name != "myproject-default" || n.Driver != "bridge" || n.Subnet == "" {
+		t.Errorf("unexpected generated project network: %+v", n)
+	}
+	_, subnet, _ := net.ParseCIDR(n.Subnet)
+	other, err := projectNetwork(d, "otherproject", []*net.IPNet{subnet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Subnet == n.Subnet || !strings.HasPrefix(other.Subnet, "10.1") {
+		t.Errorf("second project's subnet should not overlap used subnet %s but was %s", n.Subnet, other.Subnet)
+	}
+	_, host, _ := net.ParseCIDR("10.0.0.0/8")
+	if _, err = projectNetwork(d, "otherproject", []*net.IPNet{host}); err == nil {
+		t.Errorf("projectNetwork() should return error when no subnet is free")
+	}
+	if other, _ = projectNetwork(d, "myproject", []*net.IPNet{subnet}); other.Subnet == n.Subnet {
+		t.Errorf("projectNetwork() should skip used subnet %s", n.Subnet)
+	}
+	if other, _ := projectNetwork(d, "myproject", nil); other.Subnet != n.Subnet {
+		t.Errorf("project network subnet should be derived from the project name")
+	}
+	d.Networks[PROJECT_NETWORK] = &model.NetworkDescriptor{Subnet: "10.8.0.0/24"}
+	if n, err = projectNetwork(d, "myproject", nil); err != nil || n.Subnet != "10.8.0.0/24" {
+		t.Errorf("projectNetwork() should return the declared default network but returned %+v, %v", n, err)
+	}
+	d.Networks[PROJECT_NETWORK] = &model.NetworkDescriptor{Driver: "host"}
+	if _, err = projectNetwork(d, "myproject", nil); err == nil {
+		t.Errorf("projectNetwork() should return error for host network")
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t00000000\t0100A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n" +
+		"eth0\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+		"docker0\t000011AC\t00000000\t0001\t0\t0\t0\t0000FFFF\t0\t0\t0\n"
+	actual := []string{}
+	for _, n := range parseRoutes(table) {
+		actual = append(actual, n.String())
+	}
+	expected := []string{"192.168.0.0/24", "172.17.0.0/16"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("parseRoutes() should return %v but returned %v", expected, actual)
+	}
+}
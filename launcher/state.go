@@ -0,0 +1,206 @@
+package launcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/checks"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Running pod as published within the state directory
+type PodState struct {
+	UUID      string    `json:"uuid"`
+	UUIDFile  string    `json:"uuid_file,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Pod       *Pod      `json:"pod"`
+}
+
+// Combined runtime information of a running pod
+type PodInspection struct {
+	*PodState
+	Container *ContainerInfo `json:"container"`
+	Health    *PodHealth     `json:"health,omitempty"`
+}
+
+// Health state of a running pod as published within the state directory
+type PodHealth struct {
+	Name   string                     `json:"name"`
+	UUID   string                     `json:"uuid"`
+	Status string                     `json:"status"`
+	Output string                     `json:"output"`
+	Checks []*checks.HealthCheckState `json:"checks"`
+}
+
+// Returns the check state of the given service or nil if it has no check
+func (h *PodHealth) Check(service string) *checks.HealthCheckState {
+	for _, c := range h.Checks {
+		if c.Name == service {
+			return c
+		}
+	}
+	return nil
+}
+
+func (ctx *PodLauncher) podStateDir() string {
+	return filepath.Join(ctx.stateDir, ctx.podUUID)
+}
+
+func (ctx *PodLauncher) writePodState() error {
+	if ctx.stateDir == "" {
+		return nil
+	}
+	s := &PodState{ctx.podUUID, ctx.podUUIDFile, time.Now(), ctx.descriptor}
+	return writeStateFile(ctx.podStateDir(), "pod.json", s)
+}
+
+func (ctx *PodLauncher) writeHealthState(c *checks.HealthChecks) {
+	status := c.Status()
+	h := &PodHealth{ctx.descriptor.Name, ctx.podUUID, status.Status().String(), status.Output(), c.States()}
+	if err := writeStateFile(ctx.podStateDir(), "health.json", h); err != nil {
+		ctx.error.Println(err)
+	}
+}
+
+func (ctx *PodLauncher) removeState() {
+	if ctx.stateDir != "" && ctx.podUUID != "" {
+		if err := os.RemoveAll(ctx.podStateDir()); err != nil {
+			ctx.error.Printf("Cannot remove pod state: %s", err)
+		}
+	}
+}
+
+func writeStateFile(dir, name string, v interface{}) error {
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Cannot marshal %s: %s", name, err)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Cannot create state directory: %s", err)
+	}
+	// Write to temp file first to avoid exposing partially written state
+	f, err := ioutil.TempFile(dir, "."+name+"-")
+	if err != nil {
+		return fmt.Errorf("Cannot create state file: %s", err)
+	}
+	_, err = f.Write(j)
+	if e := f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Cannot write state file %s: %s", name, err)
+	}
+	return nil
+}
+
+// Reads the state of the pod with the given UUID from the state directory
+func ReadPodState(stateDir, podUUID string) (*PodState, error) {
+	s := &PodState{}
+	b, err := ioutil.ReadFile(filepath.Join(stateDir, podUUID, "pod.json"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read pod state: %s", err)
+	}
+	if err = json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal pod state: %s", err)
+	}
+	return s, nil
+}
+
+// Returns the pod's published state combined with its rkt status
+func InspectPod(stateDir, podUUID string) (*PodInspection, error) {
+	s, err := ReadPodState(stateDir, podUUID)
+	if err != nil {
+		return nil, err
+	}
+	r := &PodInspection{PodState: s}
+	r.Container, err = PodContainerInfo(podUUID)
+	if err != nil {
+		r.Container = &ContainerInfo{State: "unknown"}
+	}
+	r.Health, _ = ReadPodHealth(stateDir, podUUID)
+	return r, nil
+}
+
+// Reads the health state of the pod with the given UUID from the state directory
+func ReadPodHealth(stateDir, podUUID string) (*PodHealth, error) {
+	h := &PodHealth{}
+	b, err := ioutil.ReadFile(filepath.Join(stateDir, podUUID, "health.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No health state found for pod %s. The pod has no health checks or has been started without -state-dir", podUUID)
+		}
+		return nil, fmt.Errorf("Cannot read pod health: %s", err)
+	}
+	if err = json.Unmarshal(b, h); err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal pod health: %s", err)
+	}
+	return h, nil
+}
+
+// Resolves the UUID of a pod known in the state directory.
+// The pod is identified by UUID file or name. If no pod has the name it is
+// matched as UUID prefix. If neither is provided the only known pod is returned.
+func ResolvePodUUID(stateDir, uuidFile, name string) (string, error) {
+	if uuidFile != "" {
+		b, err := ioutil.ReadFile(uuidFile)
+		if err != nil {
+			return "", fmt.Errorf("Cannot read pod UUID file: %s", err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	uuids, err := KnownPodUUIDs(stateDir)
+	if err != nil {
+		return "", err
+	}
+	candidates := []string{}
+	for _, uuid := range uuids {
+		if name == "" {
+			candidates = append(candidates, uuid)
+		} else if s, err := ReadPodState(stateDir, uuid); err == nil && s.Pod.Name == name {
+			candidates = append(candidates, uuid)
+		}
+	}
+	if len(candidates) == 0 && name != "" {
+		for _, uuid := range uuids {
+			if strings.HasPrefix(uuid, name) {
+				candidates = append(candidates, uuid)
+			}
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		if name == "" {
+			return "", fmt.Errorf("No running pod found in %s", stateDir)
+		}
+		return "", fmt.Errorf("No running pod named %q found in %s", name, stateDir)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("Multiple pods found in %s. Please specify the pod by -name or -uuid-file", stateDir)
+	}
+}
+
+// Returns the UUIDs of the pods published within the state directory
+func KnownPodUUIDs(stateDir string) ([]string, error) {
+	files, err := ioutil.ReadDir(stateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("Cannot read state directory: %s", err)
+	}
+	r := []string{}
+	for _, f := range files {
+		if f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+			r = append(r, f.Name())
+		}
+	}
+	return r, nil
+}
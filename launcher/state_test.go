@@ -0,0 +1,40 @@
+package launcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePodUUID(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "rkt-compose-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	for uuid, name := range map[string]string{"abc123": "web", "def456": "abc", "abd789": "db"} {
+		s := &PodState{UUID: uuid, Pod: &Pod{Name: name}}
+		if err = writeStateFile(filepath.Join(stateDir, uuid), "pod.json", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"web", "abc123"},
+		{"abc", "def456"}, // name takes precedence over UUID prefix
+		{"abd", "abd789"},
+		{"def4", "def456"},
+	} {
+		if uuid, err := ResolvePodUUID(stateDir, "", c.name); err != nil || uuid != c.expected {
+			t.Errorf("ResolvePodUUID(%q) should return %s but returned %q, %v", c.name, c.expected, uuid, err)
+		}
+	}
+	for _, name := range []string{"ab", "unknown", ""} {
+		if uuid, err := ResolvePodUUID(stateDir, "", name); err == nil {
+			t.Errorf("ResolvePodUUID(%q) should return error but returned %s", name, uuid)
+		}
+	}
+}
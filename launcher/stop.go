@@ -0,0 +1,208 @@
+package launcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGKILL":  syscall.SIGKILL,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGWINCH": syscall.SIGWINCH,
+	"SIGPWR":   syscall.SIGPWR,
+}
+
+// Returns the normalized name of a signal provided as name (with or without SIG prefix) or number
+func parseSignal(v string) (string, error) {
+	name := strings.ToUpper(v)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if _, ok := signals[name]; ok {
+		return name, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		for name, s := range signals {
+			if int(s) == n {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unsupported signal %q", v)
+}
+
+// Stops the apps in reverse dependency order. Each app receives its stop
+// signal (SIGTERM by default) and is killed when it did not terminate within
+// its stop grace period. Apps without dependencies between them are stopped
+// concurrently. Returns false if the apps could not be resolved.
+func (ctx *PodLauncher) stopApps() bool {
+	info, err := PodContainerInfo(ctx.podUUID)
+	if err != nil || info.Pid == 0 {
+		ctx.error.Printf("Cannot stop apps individually since pod PID is unknown: %s", err)
+		return false
+	}
+	for _, wave := range shutdownOrder(ctx.descriptor.Services) {
+		var wg sync.WaitGroup
+		for _, name := range wave {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				ctx.stopApp(info.Pid, name, ctx.descriptor.Services[name])
+			}(name)
+		}
+		wg.Wait()
+	}
+	return true
+}
+
+func (ctx *PodLauncher) stopApp(podPid int, name string, s *Service) {
+	pid, err := appPid("/proc", podPid, name)
+	if err != nil {
+		ctx.debug.Printf("Cannot stop %s: %s", name, err)
+		return
+	}
+	sig := s.StopSignal
+	if sig == "" {
+		sig = "SIGTERM"
+	}
+	ctx.debug.Printf("Sending %s to %s", sig, name)
+	if err = syscall.Kill(pid, signals[sig]); err != nil {
+		ctx.error.Printf("Cannot send %s to %s: %s", sig, name, err)
+		return
+	}
+	if !waitForExit([]int{pid}, s.StopGracePeriod) {
+		ctx.error.Printf("Killing %s since stop grace period of %s exceeded", name, s.StopGracePeriod)
+		if err = syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			ctx.error.Printf("Cannot kill %s: %s", name, err)
+		}
+	}
+}
+
+// Returns the service names grouped into waves that can be stopped one after
+// another: a service is stopped before the services it depends on.
+// Services within a dependency cycle are stopped within the last wave.
+func shutdownOrder(services map[string]*Service) [][]string {
+	deps := map[string][]string{}
+	for name, s := range services {
+		deps[name] = s.DependsOn
+	}
+	return dependencyWaves(deps)
+}
+
+// Groups the keys into waves: a key's dependents are contained in previous waves
+func dependencyWaves(deps map[string][]string) [][]string {
+	dependents := map[string]int{}
+	for _, d := range deps {
+		for _, dep := range d {
+			if _, ok := deps[dep]; ok {
+				dependents[dep]++
+			}
+		}
+	}
+	r := [][]string{}
+	remaining := map[string]bool{}
+	for name := range deps {
+		remaining[name] = true
+	}
+	for len(remaining) > 0 {
+		wave := []string{}
+		for name := range remaining {
+			if dependents[name] == 0 {
+				wave = append(wave, name)
+			}
+		}
+		if len(wave) == 0 {
+			for name := range remaining {
+				wave = append(wave, name)
+			}
+		}
+		sort.Strings(wave)
+		for _, name := range wave {
+			delete(remaining, name)
+			for _, dep := range deps[name] {
+				dependents[dep]--
+			}
+		}
+		r = append(r, wave)
+	}
+	return r
+}
+
+// Waits until the processes terminated or the timeout exceeded.
+// Returns false if the timeout exceeded.
+func waitForExit(pids []int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		running := false
+		for _, pid := range pids {
+			if syscall.Kill(pid, 0) == nil {
+				running = true
+				break
+			}
+		}
+		if !running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Returns the host PID of an app's main process.
+// Within rkt's systemd based stage1 each app is run as a child of the pod's
+// systemd process within the cgroup of the app's service unit.
+func appPid(procDir string, podPid int, app string) (int, error) {
+	dirs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return 0, err
+	}
+	unit := "/" + app + ".service"
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || !d.IsDir() {
+			continue
+		}
+		if ppid, err := parentPid(filepath.Join(procDir, d.Name(), "stat")); err != nil || ppid != podPid {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(procDir, d.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if strings.HasSuffix(line, unit) {
+				return pid, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no process found for app %s of pod process %d", app, podPid)
+}
+
+// Reads the parent PID from a /proc/PID/stat file
+func parentPid(statFile string) (int, error) {
+	b, err := ioutil.ReadFile(statFile)
+	if err != nil {
+		return 0, err
+	}
+	// The command name in parentheses may contain spaces
+	s := string(b)
+	f := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(f) < 2 {
+		return 0, fmt.Errorf("invalid stat file %s", statFile)
+	}
+	return strconv.Atoi(f[1])
+}
@@ -0,0 +1,76 @@
+package launcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	for v, expected := range map[string]string{"SIGQUIT": "SIGQUIT", "int": "SIGINT", "USR1": "SIGUSR1", "15": "SIGTERM"} {
+		sig, err := parseSignal(v)
+		if err != nil {
+			t.Errorf("parseSignal(%q) returned error: %s", v, err)
+		} else if sig != expected {
+			t.Errorf("parseSignal(%q) should return %s but returned %s", v, expected, sig)
+		}
+	}
+	if _, err := parseSignal("SIGFOO"); err == nil {
+		t.Errorf("parseSignal(\"SIGFOO\") should return error")
+	}
+}
+
+func TestShutdownOrder(t *testing.T) {
+	services := map[string]*Service{
+		"db":     &Service{},
+		"cache":  &Service{},
+		"app":    &Service{DependsOn: []string{"db", "cache"}},
+		"web":    &Service{DependsOn: []string{"app"}},
+		"worker": &Service{DependsOn: []string{"db"}},
+		"a":      &Service{DependsOn: []string{"b"}},
+		"b":      &Service{DependsOn: []string{"a"}},
+	}
+	expected := [][]string{{"web", "worker"}, {"app"}, {"cache", "db"}, {"a", "b"}}
+	if order := shutdownOrder(services); !reflect.DeepEqual(order, expected) {
+		t.Errorf("shutdownOrder() should return %v but returned %v", expected, order)
+	}
+}
+
+func TestAppPid(t *testing.T) {
+	procDir, err := ioutil.TempDir("", "rkt-compose-proc-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(procDir)
+	writeProc(t, procDir, "100", "100 (systemd) S 99 100", "1:name=systemd:/machine.slice/pod.scope/system.slice")
+	writeProc(t, procDir, "101", "101 (sh -c x) S 100 101", "1:name=systemd:/machine.slice/pod.scope/system.slice/db.service")
+	writeProc(t, procDir, "102", "102 (nginx) S 100 102", "1:name=systemd:/machine.slice/pod.scope/system.slice/web.service")
+	writeProc(t, procDir, "103", "103 (nginx) S 102 102", "1:name=systemd:/machine.slice/pod.scope/system.slice/web.service")
+	writeProc(t, procDir, "200", "200 (nginx) S 199 200", "1:name=systemd:/machine.slice/other.scope/system.slice/web.service")
+	for app, expected := range map[string]int{"db": 101, "web": 102} {
+		pid, err := appPid(procDir, 100, app)
+		if err != nil {
+			t.Errorf("appPid(%s) returned error: %s", app, err)
+		} else if pid != expected {
+			t.Errorf("appPid(%s) should return %d but returned %d", app, expected, pid)
+		}
+	}
+	if _, err := appPid(procDir, 100, "unknown"); err == nil {
+		t.Errorf("appPid() should return error for unknown app")
+	}
+}
+
+func writeProc(t *testing.T, procDir, pid, stat, cgroup string) {
+	dir := filepath.Join(procDir, pid)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup"), []byte(cgroup+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/mgoltzsche/rkt-compose/launcher"
 	"github.com/mgoltzsche/rkt-compose/log"
 	"github.com/mgoltzsche/rkt-compose/model"
 	"os"
+	"os/exec"
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
@@ -46,15 +51,30 @@ var (
 	var dumpOpts DumpOptions*/
 
 	// global options
-	verbose  bool
-	fetchUid string
-	fetchGid string
+	verbose          bool
+	fetchUid         string
+	fetchGid         string
+	builder          string
+	parallel         uint
+	noCache          bool
+	pullBase         bool
+	pullPolicy       = PullPolicyValue(model.PULL_NEW)
+	trustKeysDir     string
+	registryAuth     StringSlice
+	imageCacheDir    string
+	dryRun           bool
+	stateDir         string
+	files            StringSlice
+	envFiles         StringSlice
+	projectDirectory string
 
 	// run options
 	PodFile string
 
 	uuidFile               string
 	name                   string
+	project                bool
+	profiles               StringSlice
 	net                    StringSlice
 	dns                    StringSlice
 	defaultVolumeDirectory string
@@ -63,6 +83,11 @@ var (
 	consulApiPort          uint
 	consulDatacenter       string
 	consulCheckTtl         time.Duration
+	consulStartupTimeout   time.Duration
+	consulRetries          uint
+	healthHistory          uint
+	flapThreshold          uint
+	flapWindow             time.Duration
 
 	// runtime vars
 	errorLog      = log.NewStdLogger(os.Stderr)
@@ -83,12 +108,39 @@ func (s *StringSlice) String() string {
 	return fmt.Sprintf("%v", *s)
 }
 
+// Pull policy flag
+type PullPolicyValue model.PullPolicy
+
+var _ flag.Value = new(PullPolicyValue)
+
+func (p *PullPolicyValue) Set(v string) error {
+	switch model.PullPolicy(v) {
+	case model.PULL_NEVER, model.PULL_NEW, model.PULL_UPDATE:
+		*p = PullPolicyValue(v)
+		return nil
+	}
+	return fmt.Errorf("expected never, new or update")
+}
+
+func (p *PullPolicyValue) String() string {
+	return string(*p)
+}
+
 func initFlags() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s OPTIONS ARGUMENTS\n", os.Args[0])
 		fmt.Fprint(os.Stderr, "\nArguments:\n")
-		fmt.Fprintf(os.Stderr, "  run PODFILE\n\tRuns pod from docker-compose.yml or pod.json file\n")
+		fmt.Fprintf(os.Stderr, "  run PODFILE [SERVICE...]\n\tRuns pod from docker-compose.yml or pod.json file. If services are provided only those and their dependencies are run\n")
+		fmt.Fprintf(os.Stderr, "  run -project PODFILE [SERVICE...]\n\tRuns each service (or group of services sharing the same x-pod) as a separate pod. The pods are started in dependency order and stopped together\n")
+		fmt.Fprintf(os.Stderr, "  run-service PODFILE SERVICE [CMD...]\n\tRuns a throwaway pod containing only the service\n")
+		fmt.Fprintf(os.Stderr, "  exec SERVICE CMD...\n\tExecutes a command within a running service\n")
+		fmt.Fprintf(os.Stderr, "  build PODFILE [SERVICE...]\n\tBuilds the images of the pod's (or the provided) services\n")
+		fmt.Fprintf(os.Stderr, "  pull PODFILE\n\tFetches the latest images of the pod's services\n")
+		fmt.Fprintf(os.Stderr, "  image-gc [PODFILE...]\n\tRemoves built and fetched images referenced neither by the pod files nor by running pods\n")
 		fmt.Fprintf(os.Stderr, "  json PODFILE\n\tPrints pod model from file as JSON\n")
+		fmt.Fprintf(os.Stderr, "  health SERVICE\n\tPrints a running service's health check history\n")
+		fmt.Fprintf(os.Stderr, "  ps\n\tLists running pods\n")
+		fmt.Fprintf(os.Stderr, "  inspect POD\n\tPrints a running pod's state as JSON. POD can be a name or UUID\n")
 		fmt.Fprint(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
 	}
@@ -96,9 +148,24 @@ func initFlags() {
 	flag.BoolVar(&verbose, "verbose", false, "enables verbose log output")
 	flag.StringVar(&fetchUid, "fetch-uid", "0", "sets the user to fetch images with")
 	flag.StringVar(&fetchGid, "fetch-gid", "0", "sets the group to fetch images with")
+	flag.StringVar(&builder, "builder", "docker", "sets the image builder: docker, buildah or img")
+	flag.UintVar(&parallel, "parallel", 4, "sets the max number of images pulled or built concurrently")
+	flag.BoolVar(&noCache, "no-cache", false, "builds images without cache")
+	flag.Var(&pullPolicy, "pull", "sets the image pull policy: never, new or update. A service's pull_policy takes precedence")
+	flag.BoolVar(&pullBase, "pull-base", false, "pulls newer base images when building")
+	flag.StringVar(&trustKeysDir, "trust-keys-dir", "", "directory containing the keys signed ACIs are verified with (rkt trustedkeys layout)")
+	flag.Var(&registryAuth, "registry-auth", "docker config.json file containing registry credentials. Can be provided multiple times. ~/.docker/config.json is read if it exists")
+	flag.StringVar(&imageCacheDir, "image-cache-dir", "/var/cache/rkt-compose/images", "directory image metadata is cached in. Empty disables the cache")
+	flag.BoolVar(&dryRun, "dry-run", false, "lists the images image-gc would remove without removing them")
+	flag.StringVar(&stateDir, "state-dir", "/var/run/rkt-compose", "directory the state of running pods is published to")
+	flag.Var(&envFiles, "env-file", "env file variables within the descriptor are substituted with. Can be provided multiple times. Defaults to .env within the project directory")
+	flag.StringVar(&projectDirectory, "project-directory", "", "directory relative paths within the descriptor files are resolved against and the .env file is looked up in. Defaults to the PODFILE's directory")
+	flag.Var(&files, "f", "descriptor file merged into PODFILE. Can be provided multiple times. Disables docker-compose.override.yml lookup")
 	// run options
 	flag.StringVar(&uuidFile, "uuid-file", "", "file to save pod UUID to to remove last container on start")
 	flag.StringVar(&name, "name", "", "pod name used for service discovery and as default hostname")
+	flag.BoolVar(&project, "project", false, "runs each service (or group of services sharing the same x-pod) as a separate pod. The pods share the project network and resolve each other's services")
+	flag.Var(&profiles, "profile", "activates a service profile. Defaults to $COMPOSE_PROFILES")
 	flag.Var(&net, "net", "List of networks")
 	flag.Var(&dns, "dns", "List of DNS server IPs")
 	flag.StringVar(&defaultVolumeDirectory, "default-volume-dir", "./volumes", "Default volume base directory")
@@ -107,6 +174,11 @@ func initFlags() {
 	flag.UintVar(&consulApiPort, "consul-api-port", 8500, "sets consul API port")
 	flag.StringVar(&consulDatacenter, "consul-datacenter", "dc1", "sets consul datacenter")
 	flag.DurationVar(&consulCheckTtl, "consul-check-ttl", time.Duration(60000000000), "sets consul check TTL")
+	flag.DurationVar(&consulStartupTimeout, "consul-startup-timeout", 30*time.Second, "sets max duration to wait for consul to become available")
+	flag.UintVar(&consulRetries, "consul-retries", 5, "sets max retries of a failed consul request")
+	flag.UintVar(&healthHistory, "health-history", 10, "sets the number of results kept per health check")
+	flag.UintVar(&flapThreshold, "flap-threshold", 5, "sets the status changes within -flap-window after which a check is considered flapping. 0 disables flap detection")
+	flag.DurationVar(&flapWindow, "flap-window", 10*time.Minute, "sets the flap detection window")
 }
 
 func main() {
@@ -118,7 +190,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	if flag.NArg() != 2 {
+	if flag.NArg() == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -127,20 +199,70 @@ func main() {
 
 	switch flag.Arg(0) {
 	case "run":
-		err = runPod(flag.Arg(1))
+		if flag.NArg() < 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		err = runPod(flag.Arg(1), flag.Args()[2:])
+	case "run-service":
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		err = runService(flag.Arg(1), flag.Arg(2), flag.Args()[3:])
+	case "exec":
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		err = execService(flag.Arg(1), flag.Args()[2:])
+	case "build":
+		if flag.NArg() < 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		err = buildImages(flag.Arg(1), flag.Args()[2:])
+	case "pull":
+		assertArgs(1)
+		err = pullImages(flag.Arg(1))
+	case "image-gc":
+		err = gcImages(flag.Args()[1:])
 	case "json":
+		assertArgs(1)
 		err = dumpJSON(flag.Arg(1))
+	case "health":
+		assertArgs(1)
+		err = printHealth(flag.Arg(1))
+	case "ps":
+		assertArgs(0)
+		err = listPods()
+	case "inspect":
+		assertArgs(1)
+		err = inspectPod(flag.Arg(1))
 	default:
 		errorLog.Printf("Invalid argument %q", flag.Arg(0))
 		os.Exit(1)
 	}
 
 	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// Propagate the exit code of a command run within a container
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+				os.Exit(status.ExitStatus())
+			}
+		}
 		errorLog.Println(err)
 		os.Exit(2)
 	}
 }
 
+func assertArgs(n int) {
+	if flag.NArg() != n+1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
 func validateFlags() error {
 	// Init logger
 	if verbose {
@@ -185,62 +307,198 @@ func validateFlags() error {
 	}
 	fetchImagesAs.Uid = uint32(uid)
 	fetchImagesAs.Gid = uint32(gid)
+	// Init profiles
+	if len(profiles) == 0 && os.Getenv("COMPOSE_PROFILES") != "" {
+		profiles = strings.Split(os.Getenv("COMPOSE_PROFILES"), ",")
+	}
 	return nil
 }
 
-func runPod(podFile string) (err error) {
-	models := model.NewDescriptors(defaultVolumeDirectory)
-	imgs := model.NewImages(model.PULL_NEW, &fetchImagesAs, debugLog)
-	loader := launcher.NewLoader(models, imgs, defaultVolumeDirectory, errorLog, debugLog)
-	descr, err := models.Descriptor(podFile)
+// Loads the pod with the given services.
+// If selectDeps is true the services enabled by the active profiles or the
+// provided services and their dependencies are loaded.
+func loadPod(podFile string, services []string, selectDeps bool) (pod *launcher.Pod, err error) {
+	descr, loader, services, err := loadDescriptor(podFile, services, selectDeps)
 	if err != nil {
 		return
 	}
-	if len(name) > 0 {
-		descr.Name = name
+	pod, err = loader.LoadPodServices(descr, services)
+	if err != nil {
+		return
 	}
-	pod, err := loader.LoadPod(descr)
+	applyNetFlags(pod)
+	return
+}
+
+// Loads the project's pods in start order
+func loadProject(podFile string, services []string) (pods []*launcher.Pod, err error) {
+	descr, loader, services, err := loadDescriptor(podFile, services, true)
 	if err != nil {
 		return
 	}
+	pods, err = loader.LoadProject(descr, services)
+	if err != nil {
+		return
+	}
+	for _, pod := range pods {
+		applyNetFlags(pod)
+	}
+	return
+}
+
+func loadDescriptor(podFile string, services []string, selectDeps bool) (*model.PodDescriptor, *launcher.Loader, []string, error) {
+	descr, loader, err := newLoader(podFile, model.PullPolicy(pullPolicy))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(name) > 0 {
+		descr.Name = name
+	}
+	if selectDeps {
+		if services, err = descr.SelectServices(profiles, services); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return descr, loader, services, nil
+}
+
+func applyNetFlags(pod *launcher.Pod) {
 	if len(net) > 0 {
 		pod.Net = net
 	}
 	if len(dns) > 0 {
 		pod.Dns = dns
 	}
-	var cfg = &launcher.Config{}
+}
+
+func newLauncherConfig(pod *launcher.Pod) *launcher.Config {
+	cfg := &launcher.Config{}
 	cfg.Pod = pod
-	cfg.UUIDFile = uuidFile
 	cfg.DefaultPublishIP = defaultPublishIP
+	cfg.StateDir = stateDir
+	cfg.HealthHistorySize = healthHistory
+	cfg.FlapThreshold = flapThreshold
+	cfg.FlapWindow = flapWindow
 	cfg.Debug = debugLog
 	cfg.Error = errorLog
+	return cfg
+}
+
+func runPod(podFile string, services []string) (err error) {
+	if project {
+		return runProject(podFile, services)
+	}
+	pod, err := loadPod(podFile, services, true)
+	if err != nil {
+		return
+	}
+	cfg, err := newRunConfig(pod, uuidFile)
+	if err != nil {
+		return
+	}
+	l, err := launcher.NewPodLauncher(cfg)
+	if err != nil {
+		return
+	}
+	handleSignals(l)
+	defer l.MarkGarbageContainersQuiet()
+	return l.Run()
+}
+
+// Runs the project's pods as one unit
+func runProject(podFile string, services []string) (err error) {
+	pods, err := loadProject(podFile, services)
+	if err != nil {
+		return
+	}
+	launchers := make([]*launcher.PodLauncher, len(pods))
+	for i, pod := range pods {
+		podUUIDFile := ""
+		if uuidFile != "" {
+			podUUIDFile = uuidFile + "." + pod.Hostname
+		}
+		cfg, err := newRunConfig(pod, podUUIDFile)
+		if err != nil {
+			return err
+		}
+		if launchers[i], err = launcher.NewPodLauncher(cfg); err != nil {
+			return err
+		}
+	}
+	p := launcher.NewProjectLauncher(launchers)
+	handleSignals(p)
+	defer p.MarkGarbageContainersQuiet()
+	return p.Run()
+}
+
+func newRunConfig(pod *launcher.Pod, uuidFile string) (*launcher.Config, error) {
+	cfg := newLauncherConfig(pod)
+	cfg.UUIDFile = uuidFile
 	if len(consulIP) > 0 {
 		// Enable consul service discovery
 		globalNS := "service." + consulDatacenter + ".consul"
-		localNS := descr.Name + "." + globalNS
+		localNS := pod.Name + "." + globalNS
 		pod.Dns = []string{consulIP}
 		pod.DnsSearch = []string{localNS, globalNS}
-		listener, err := launcher.NewConsulLifecycleFactory("http://"+consulIP+":"+strconv.Itoa(int(consulApiPort)), consulCheckTtl, debugLog)
+		consulCfg := &launcher.ConsulConfig{}
+		consulCfg.Address = "http://" + consulIP + ":" + strconv.Itoa(int(consulApiPort))
+		consulCfg.StartupTimeout = consulStartupTimeout
+		consulCfg.CheckTTL = consulCheckTtl
+		consulCfg.Backoff = launcher.DefaultBackoff()
+		consulCfg.Backoff.MaxRetries = consulRetries
+		listener, err := launcher.NewConsulLifecycleFactory(consulCfg, debugLog)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		cfg.ListenerFactory = listener
 	}
+	return cfg, nil
+}
+
+func runService(podFile, service string, cmd []string) (err error) {
+	pod, err := loadPod(podFile, []string{service}, false)
+	if err != nil {
+		return
+	}
+	pod, err = launcher.OneOffPod(pod, service, cmd)
+	if err != nil {
+		return
+	}
+	cfg := newLauncherConfig(pod)
+	cfg.Interactive = true
 	l, err := launcher.NewPodLauncher(cfg)
 	if err != nil {
 		return
 	}
 	handleSignals(l)
 	defer l.MarkGarbageContainersQuiet()
-	err = l.Start()
+	return l.Run()
+}
+
+func execService(service string, cmd []string) error {
+	podUUID, err := launcher.ResolvePodUUID(stateDir, uuidFile, name)
+	if err != nil {
+		return err
+	}
+	state, err := launcher.ReadPodState(stateDir, podUUID)
 	if err != nil {
 		return err
 	}
-	return l.Wait()
+	c, err := launcher.ExecCommand(state.Pod, podUUID, service, cmd, launcher.IsTerminal())
+	if err != nil {
+		return err
+	}
+	debugLog.Println("Executing: rkt ", strings.Join(c.Args[1:], " "))
+	// The terminal delivers SIGINT to the command directly
+	signal.Ignore(os.Interrupt)
+	return c.Run()
 }
 
-func handleSignals(l *launcher.PodLauncher) {
+type stopper interface {
+	Stop() error
+}
+
+func handleSignals(l stopper) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	go func() {
@@ -253,15 +511,271 @@ func handleSignals(l *launcher.PodLauncher) {
 }
 
 func dumpJSON(podFile string) error {
-	descrFile, err := filepath.Abs(filepath.FromSlash(podFile))
+	models, err := newDescriptors(podFile)
 	if err != nil {
 		return err
 	}
-	models := model.NewDescriptors(defaultVolumeDirectory)
-	descr, err := models.Descriptor(descrFile)
+	descr, err := models.MergedDescriptor(podFiles(podFile)...)
 	if err != nil {
 		return err
 	}
 	fmt.Println(descr.JSON())
 	return err
 }
+
+// Loads the descriptor and creates a loader for it
+func newLoader(podFile string, pullPolicy model.PullPolicy) (*model.PodDescriptor, *launcher.Loader, error) {
+	imgs, err := newImages(pullPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newLoaderWithImages(podFile, imgs)
+}
+
+func newLoaderWithImages(podFile string, imgs *model.Images) (*model.PodDescriptor, *launcher.Loader, error) {
+	models, err := newDescriptors(podFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	descr, err := models.MergedDescriptor(podFiles(podFile)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return descr, launcher.NewLoader(models, imgs, defaultVolumeDirectory, errorLog, debugLog), nil
+}
+
+func newImages(pullPolicy model.PullPolicy) (*model.Images, error) {
+	imageBuilder, err := model.NewImageBuilder(builder, debugLog)
+	if err != nil {
+		return nil, err
+	}
+	imgs := model.NewImages(pullPolicy, &fetchImagesAs, imageBuilder, debugLog)
+	imgs.SetBuildOptions(noCache, pullBase)
+	imgs.SetTrustKeysDir(trustKeysDir)
+	if imageCacheDir != "" {
+		imgs.SetCacheDir(imageCacheDir)
+	}
+	auth, err := readRegistryAuth()
+	if err != nil {
+		return nil, err
+	}
+	imgs.SetRegistryAuth(auth)
+	return imgs, nil
+}
+
+// Removes the images that are referenced neither by the provided descriptors
+// nor by the pods within the state directory
+func gcImages(podFiles []string) error {
+	imgs, err := newImages(model.PULL_NEVER)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	ids := []string{}
+	for _, podFile := range podFiles {
+		descr, loader, err := newLoaderWithImages(podFile, imgs)
+		if err != nil {
+			return err
+		}
+		n, err := loader.ImageNames(descr)
+		if err != nil {
+			return fmt.Errorf("%s: %s", podFile, err)
+		}
+		names = append(names, n...)
+	}
+	uuids, err := launcher.KnownPodUUIDs(stateDir)
+	if err != nil {
+		return err
+	}
+	for _, uuid := range uuids {
+		s, err := launcher.ReadPodState(stateDir, uuid)
+		if err != nil {
+			errorLog.Printf("Skipping pod %s: %s", uuid, err)
+			continue
+		}
+		for _, service := range s.Pod.Services {
+			names = append(names, service.Image)
+			if service.ImageID != "" {
+				ids = append(ids, service.ImageID)
+			}
+		}
+	}
+	removed, err := imgs.GarbageCollect(names, ids, dryRun)
+	for _, img := range removed {
+		if dryRun {
+			fmt.Printf("Would remove %s %s\n", img.ID, img.Name)
+		} else {
+			fmt.Printf("Removed %s %s\n", img.ID, img.Name)
+		}
+	}
+	return err
+}
+
+func buildImages(podFile string, services []string) error {
+	descr, loader, err := newLoader(podFile, model.PullPolicy(pullPolicy))
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		if services, err = descr.SelectServices(profiles, nil); err != nil {
+			return err
+		}
+	}
+	return loader.BuildImages(descr, services, parallel)
+}
+
+func pullImages(podFile string) error {
+	descr, loader, err := newLoader(podFile, model.PULL_UPDATE)
+	if err != nil {
+		return err
+	}
+	services, err := descr.SelectServices(profiles, nil)
+	if err != nil {
+		return err
+	}
+	return loader.PullImages(descr, services, parallel)
+}
+
+// Reads the registry credentials from ~/.docker/config.json (if it exists)
+// overridden by the -registry-auth files
+func readRegistryAuth() (model.RegistryAuth, error) {
+	files := []string{}
+	dockerConfig := model.DockerConfigFile()
+	if _, err := os.Stat(dockerConfig); err == nil {
+		files = append(files, dockerConfig)
+	}
+	return model.ReadRegistryAuth(append(files, registryAuth...))
+}
+
+func newDescriptors(podFile string) (*model.Descriptors, error) {
+	files := envFiles
+	if len(files) == 0 {
+		dir := projectDirectory
+		if dir == "" {
+			dir = filepath.FromSlash(podFile)
+			if s, err := os.Stat(dir); err != nil || !s.IsDir() {
+				dir = filepath.Dir(dir)
+			}
+		}
+		envFile := filepath.Join(dir, ".env")
+		if _, err := os.Stat(envFile); err == nil {
+			files = []string{envFile}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Cannot access env file: %s", err)
+		}
+	}
+	substitutes, err := model.NewEnvSubstitutes(files, errorLog)
+	if err != nil {
+		return nil, err
+	}
+	r := model.NewDescriptors(defaultVolumeDirectory, substitutes)
+	if projectDirectory != "" {
+		if err = r.SetProjectDirectory(projectDirectory); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func podFiles(podFile string) []string {
+	return append([]string{filepath.FromSlash(podFile)}, files...)
+}
+
+func printHealth(service string) error {
+	podUUID, err := launcher.ResolvePodUUID(stateDir, uuidFile, name)
+	if err != nil {
+		return err
+	}
+	health, err := launcher.ReadPodHealth(stateDir, podUUID)
+	if err != nil {
+		return err
+	}
+	check := health.Check(service)
+	if check == nil {
+		return fmt.Errorf("Service %q of pod %s has no health check", service, podUUID)
+	}
+	flapping := ""
+	if check.Flapping {
+		flapping = " (flapping)"
+	}
+	fmt.Printf("Pod %s (%s): %s\n", health.Name, podUUID, health.Status)
+	fmt.Printf("Service %s: %s%s\n\n", service, check.Status, flapping)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tDURATION\tSTATUS\tOUTPUT")
+	for _, r := range check.History {
+		out := strings.Replace(r.Output, "\n", " ", -1)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Time.Format(time.RFC3339), r.Duration, r.Status, out)
+	}
+	return w.Flush()
+}
+
+func listPods() error {
+	uuids, err := launcher.KnownPodUUIDs(stateDir)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tUUID\tSTATE\tUPTIME\tSERVICE\tIMAGE\tPORTS\tHEALTH")
+	for _, uuid := range uuids {
+		p, err := launcher.InspectPod(stateDir, uuid)
+		if err != nil {
+			errorLog.Printf("Skipping pod %s: %s", uuid, err)
+			continue
+		}
+		startedAt := p.StartedAt
+		if p.Container.StartedAt > 0 {
+			startedAt = time.Unix(int64(p.Container.StartedAt), 0)
+		}
+		uptime := time.Since(startedAt).Round(time.Second)
+		names := make([]string, 0, len(p.Pod.Services))
+		for k := range p.Pod.Services {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			s := p.Pod.Services[k]
+			ports := make([]string, len(s.Ports))
+			for i, port := range s.Ports {
+				ports[i] = fmt.Sprintf("%d/%s", port.Target, port.Protocol)
+				if port.Published > 0 {
+					ports[i] = fmt.Sprintf("%s:%d->%s", port.IP, port.Published, ports[i])
+				}
+			}
+			health := "-"
+			if p.Health != nil {
+				if c := p.Health.Check(k); c != nil {
+					health = c.Status.String()
+					if c.Flapping {
+						health += " (flapping)"
+					}
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.Pod.Name, shortUUID(uuid), p.Container.State, uptime, k, s.Image, strings.Join(ports, ","), health)
+		}
+	}
+	return w.Flush()
+}
+
+func inspectPod(pod string) error {
+	podUUID, err := launcher.ResolvePodUUID(stateDir, "", pod)
+	if err != nil {
+		return err
+	}
+	p, err := launcher.InspectPod(stateDir, podUUID)
+	if err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Cannot marshal pod state: %s", err)
+	}
+	fmt.Println(string(j))
+	return nil
+}
+
+func shortUUID(uuid string) string {
+	if len(uuid) > 8 {
+		return uuid[:8]
+	}
+	return uuid
+}
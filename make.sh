@@ -1,6 +1,6 @@
 #! /bin/sh
 
-# Go 1.8+ required. Ubuntu installation:
+# Go 1.21+ required (google.golang.org/grpc). Ubuntu installation:
 #  sudo add-apt-repository ppa:longsleep/golang-backports
 #  sudo apt-get update
 #  sudo apt-get install golang-go
@@ -21,10 +21,13 @@ mkdir -p build/src/github.com/mgoltzsche/rkt-compose &&
 ln -sf $GOPATH/* "$GOPATH/build/src/github.com/mgoltzsche/rkt-compose/" &&
 rm "$GOPATH/build/src/github.com/mgoltzsche/rkt-compose/build" &&
 export GOPATH="$GOPATH/build" &&
+# Build within the GOPATH workspace instead of module mode
+export GO111MODULE=off &&
 
 # Fetch dependencies
 go get gopkg.in/yaml.v2 &&
 go get gopkg.in/appc/docker2aci.v0 &&
+go get google.golang.org/grpc &&
 
 # Build linked binary to $GOPATH/bin/rkt-compose
 go build -o bin/rkt-compose github.com/mgoltzsche/rkt-compose &&
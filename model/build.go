@@ -0,0 +1,227 @@
+package model
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Effective image build configuration
+type BuildConfig struct {
+	Dockerfile string
+	Context    string
+	Args       map[string]string
+	Target     string
+	CacheFrom  []string
+	Labels     map[string]string
+	Network    string
+	ShmSize    string
+	// Build options that do not affect the hash
+	NoCache bool
+	Pull    bool
+	// Builds the image even if it exists already
+	Force bool
+}
+
+// Returns the docker build arguments (without the context)
+func (b *BuildConfig) dockerArgs() []string {
+	r := []string{"-f", filepath.FromSlash(b.Dockerfile)}
+	for _, k := range sortedKeys(b.Args) {
+		r = append(r, "--build-arg", k+"="+b.Args[k])
+	}
+	if b.Target != "" {
+		r = append(r, "--target", b.Target)
+	}
+	for _, img := range b.CacheFrom {
+		r = append(r, "--cache-from", img)
+	}
+	for _, k := range sortedKeys(b.Labels) {
+		r = append(r, "--label", k+"="+b.Labels[k])
+	}
+	if b.Network != "" {
+		r = append(r, "--network", b.Network)
+	}
+	if b.ShmSize != "" {
+		r = append(r, "--shm-size", b.ShmSize)
+	}
+	if b.NoCache {
+		r = append(r, "--no-cache")
+	}
+	if b.Pull {
+		r = append(r, "--pull")
+	}
+	return r
+}
+
+// Returns a hash of the Dockerfile, the context's files and the build options.
+// Files matching a pattern within the context's .dockerignore file are ignored.
+func (b *BuildConfig) Hash() (string, error) {
+	h := b.newHash()
+	if err := hashFile(h, "Dockerfile", filepath.FromSlash(b.Dockerfile)); err != nil {
+		return "", err
+	}
+	err := b.walkContext(func(rel, file string, f os.FileInfo) error {
+		fmt.Fprintf(h, "%s %s\n", rel, f.Mode())
+		if f.Mode().IsRegular() {
+			return hashFile(h, rel, file)
+		}
+		return hashLink(h, file, f)
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns a key identifying the build options and file locations and a hash
+// of the names, modes, sizes and modification times of the Dockerfile and the
+// context's files.
+// Unlike Hash() it does not read the files' contents but the stat hash changes
+// whenever Hash() may change.
+func (b *BuildConfig) statHash() (key, stat string, err error) {
+	h := b.newHash()
+	absDockerfile, err := filepath.Abs(filepath.FromSlash(b.Dockerfile))
+	if err != nil {
+		return
+	}
+	absContext, err := filepath.Abs(filepath.FromSlash(b.Context))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(h, "dockerfile=%s\ncontext=%s\n", absDockerfile, absContext)
+	key = hex.EncodeToString(h.Sum(nil))
+	f, err := os.Stat(absDockerfile)
+	if err != nil {
+		return "", "", fmt.Errorf("Cannot read Dockerfile: %s", err)
+	}
+	writeFileStat(h, "Dockerfile", f)
+	err = b.walkContext(func(rel, file string, f os.FileInfo) error {
+		writeFileStat(h, rel, f)
+		return hashLink(h, file, f)
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return key, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns a new hash containing the build options
+func (b *BuildConfig) newHash() hash.Hash {
+	h := sha256.New()
+	fmt.Fprintf(h, "target=%s\nnetwork=%s\nshm=%s\n", b.Target, b.Network, b.ShmSize)
+	for _, k := range sortedKeys(b.Args) {
+		fmt.Fprintf(h, "arg:%s=%s\n", k, b.Args[k])
+	}
+	for _, k := range sortedKeys(b.Labels) {
+		fmt.Fprintf(h, "label:%s=%s\n", k, b.Labels[k])
+	}
+	return h
+}
+
+// Calls fn for each of the context's files that is not matched by a pattern
+// within the context's .dockerignore file in lexical order
+func (b *BuildConfig) walkContext(fn func(rel, file string, f os.FileInfo) error) error {
+	ctxDir := filepath.FromSlash(b.Context)
+	ignored, err := readDockerIgnore(filepath.Join(ctxDir, ".dockerignore"))
+	if err != nil {
+		return err
+	}
+	// filepath.Walk visits files in lexical order
+	err = filepath.Walk(ctxDir, func(file string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(ctxDir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if isDockerIgnored(rel, ignored) {
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return fn(rel, file, f)
+	})
+	if err != nil {
+		return fmt.Errorf("Cannot hash build context %q: %s", b.Context, err)
+	}
+	return nil
+}
+
+func writeFileStat(h hash.Hash, name string, f os.FileInfo) {
+	fmt.Fprintf(h, "%s %s %d %d\n", name, f.Mode(), f.Size(), f.ModTime().UnixNano())
+}
+
+func hashLink(h hash.Hash, file string, f os.FileInfo) error {
+	if f.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		io.WriteString(h, target)
+	}
+	return nil
+}
+
+func hashFile(h hash.Hash, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("Cannot read %s: %s", name, err)
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return fmt.Errorf("Cannot read %s: %s", name, err)
+	}
+	return nil
+}
+
+func readDockerIgnore(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Cannot read .dockerignore: %s", err)
+	}
+	defer f.Close()
+	r := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && line[0] != '#' {
+			r = append(r, strings.Trim(filepath.ToSlash(filepath.Clean(line)), "/"))
+		}
+	}
+	return r, scanner.Err()
+}
+
+// Returns true if the path or one of its parent directories matches a pattern.
+// Exclusion patterns (!) are not supported.
+func isDockerIgnored(path string, patterns []string) bool {
+	for _, p := range patterns {
+		for f := path; f != "."; f = filepath.ToSlash(filepath.Dir(f)) {
+			if m, _ := filepath.Match(p, f); m {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	r := make([]string, 0, len(m))
+	for k := range m {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r
+}
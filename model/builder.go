@@ -0,0 +1,101 @@
+package model
+
+import (
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Builds an image and exports it as docker archive
+type ImageBuilder interface {
+	Build(name string, build *BuildConfig, archiveFile string) error
+}
+
+// Returns the builder backend with the given name: docker, buildah or img.
+// buildah and img do not require a daemon.
+func NewImageBuilder(name string, debug log.Logger) (ImageBuilder, error) {
+	switch name {
+	case "docker":
+		return &dockerBuilder{debug}, nil
+	case "buildah":
+		return &buildahBuilder{debug}, nil
+	case "img":
+		return &imgBuilder{debug}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported image builder %q. Expected docker, buildah or img", name)
+	}
+}
+
+type dockerBuilder struct {
+	debug log.Logger
+}
+
+func (b *dockerBuilder) Build(name string, build *BuildConfig, archiveFile string) error {
+	args := append(append([]string{"build", "-t", name, "--rm"}, build.dockerArgs()...), buildContext(build))
+	if err := runBuildCmd(b.debug, "docker", args...); err != nil {
+		return err
+	}
+	return runBuildCmd(b.debug, "docker", "save", "--output", archiveFile, name)
+}
+
+type buildahBuilder struct {
+	debug log.Logger
+}
+
+func (b *buildahBuilder) Build(name string, build *BuildConfig, archiveFile string) error {
+	args := append(append([]string{"bud", "-t", name}, build.dockerArgs()...), buildContext(build))
+	if err := runBuildCmd(b.debug, "buildah", args...); err != nil {
+		return err
+	}
+	// Remove archive file since buildah does not overwrite it
+	os.Remove(archiveFile)
+	return runBuildCmd(b.debug, "buildah", "push", name, "docker-archive:"+archiveFile+":"+name)
+}
+
+type imgBuilder struct {
+	debug log.Logger
+}
+
+func (b *imgBuilder) Build(name string, build *BuildConfig, archiveFile string) error {
+	args := []string{"build", "-t", name, "-f", filepath.FromSlash(build.Dockerfile)}
+	for _, k := range sortedKeys(build.Args) {
+		args = append(args, "--build-arg", k+"="+build.Args[k])
+	}
+	if build.Target != "" {
+		args = append(args, "--target", build.Target)
+	}
+	for _, k := range sortedKeys(build.Labels) {
+		args = append(args, "--label", k+"="+build.Labels[k])
+	}
+	if build.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if len(build.CacheFrom) > 0 || build.Network != "" || build.ShmSize != "" || build.Pull {
+		b.debug.Printf("Warn: img builder ignores cache_from, network, shm_size and pull of image %q", name)
+	}
+	if err := runBuildCmd(b.debug, "img", append(args, buildContext(build))...); err != nil {
+		return err
+	}
+	return runBuildCmd(b.debug, "img", "save", "-o", archiveFile, name)
+}
+
+func buildContext(build *BuildConfig) string {
+	if build.Context == "" {
+		return filepath.Dir(filepath.FromSlash(build.Dockerfile))
+	}
+	return filepath.FromSlash(build.Context)
+}
+
+func runBuildCmd(debug log.Logger, cmd string, args ...string) error {
+	debug.Printf("Running %s %s", cmd, strings.Join(args, " "))
+	c := exec.Command(cmd, args...)
+	c.Stdout = os.Stdout // TODO: write to log
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s %s: %s", cmd, args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Creates substitutes from the given env files overridden by the process environment
+func NewEnvSubstitutes(envFiles []string, warn log.Logger) (*Substitutes, error) {
+	env := map[string]string{}
+	for _, f := range envFiles {
+		if err := ReadEnvFile(f, env, warn); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range os.Environ() {
+		s := strings.SplitN(e, "=", 2)
+		env[s[0]] = s[1]
+	}
+	return NewSubstitutes(env, warn), nil
+}
+
+// Reads a service's env file into r reporting unset variables to the
+// logger variables within descriptors are substituted with
+func (self *Descriptors) ReadEnvFile(file string, r map[string]string) error {
+	warn := log.NewNopLogger()
+	if self.substitutes != nil {
+		warn = self.substitutes.warn
+	}
+	return ReadEnvFile(file, r, warn)
+}
+
+// Reads a dotenv file into r.
+// Supported syntax:
+//
+//	# comment
+//	[export] KEY=unquoted value # inline comment
+//	KEY='literal value'
+//	KEY="value with \"escapes\"\n"
+//	KEY
+//
+// Quoted values may span multiple lines.
+// Variables within unquoted and double quoted values are substituted with
+// previously read values or the process environment.
+// A KEY without value is taken from the process environment if set.
+// Unset variables are reported to warn.
+func ReadEnvFile(file string, r map[string]string, warn log.Logger) error {
+	b, err := ioutil.ReadFile(filepath.FromSlash(file))
+	if err != nil {
+		return fmt.Errorf("cannot read env file %q: %s", file, err)
+	}
+	vars := map[string]string{}
+	for _, e := range os.Environ() {
+		s := strings.SplitN(e, "=", 2)
+		vars[s[0]] = s[1]
+	}
+	for k, v := range r {
+		vars[k] = v
+	}
+	p := &dotenvParser{file, b, 0, 1, NewSubstitutes(vars, warn)}
+	for {
+		k, v, ok, err := p.next()
+		if err != nil {
+			return err
+		}
+		if k == "" {
+			break
+		}
+		if ok {
+			r[k] = v
+			vars[k] = v
+		}
+	}
+	return p.substitutes.Err()
+}
+
+type dotenvParser struct {
+	file        string
+	data        []byte
+	pos         int
+	line        int
+	substitutes *Substitutes
+}
+
+// Returns the next entry. ok is false if the entry has no value.
+func (p *dotenvParser) next() (key, value string, ok bool, err error) {
+	for {
+		p.skip(" \t\r\n")
+		if p.pos >= len(p.data) {
+			return "", "", false, nil
+		}
+		if p.data[p.pos] != '#' {
+			break
+		}
+		p.skipLine()
+	}
+	line := p.line
+	key = p.readKey()
+	if key == "export" && p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.skip(" \t")
+		key = p.readKey()
+	}
+	if key == "" {
+		return "", "", false, p.errorf(line, "missing variable name")
+	}
+	p.skip(" \t")
+	if p.pos >= len(p.data) || p.data[p.pos] == '\n' || p.data[p.pos] == '#' {
+		// KEY without value
+		p.skipLine()
+		value, ok = os.LookupEnv(key)
+		return
+	}
+	if p.data[p.pos] != '=' {
+		return "", "", false, p.errorf(line, "invalid entry for %s: '=' expected", key)
+	}
+	p.pos++
+	p.skip(" \t")
+	location := fmt.Sprintf("%s:%d", p.file, line)
+	if p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case '\'':
+			value, err = p.readQuoted('\'')
+		case '"':
+			value, err = p.readQuoted('"')
+			value = p.substitutes.SubstituteAt(value, location)
+		default:
+			value = p.substitutes.SubstituteAt(p.readUnquoted(), location)
+			return key, value, true, nil
+		}
+		if err != nil {
+			return
+		}
+		// Allow trailing whitespace and comment only
+		p.skip(" \t\r")
+		if p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '#' {
+			return "", "", false, p.errorf(p.line, "unexpected characters after quoted value of %s", key)
+		}
+		p.skipLine()
+	}
+	return key, value, true, nil
+}
+
+func (p *dotenvParser) readKey() string {
+	start := p.pos
+	for p.pos < len(p.data) && (isVarNameChar(p.data[p.pos]) || p.data[p.pos] == '.') {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+func (p *dotenvParser) readUnquoted() string {
+	start := p.pos
+	end := -1
+	for ; p.pos < len(p.data) && p.data[p.pos] != '\n'; p.pos++ {
+		if end == -1 && p.data[p.pos] == '#' && (p.data[p.pos-1] == ' ' || p.data[p.pos-1] == '\t') {
+			// Inline comment
+			end = p.pos
+		}
+	}
+	if end == -1 {
+		end = p.pos
+	}
+	return strings.TrimSpace(string(p.data[start:end]))
+}
+
+func (p *dotenvParser) readQuoted(quote byte) (string, error) {
+	line := p.line
+	p.pos++
+	var r bytes.Buffer
+	for ; p.pos < len(p.data); p.pos++ {
+		c := p.data[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return r.String(), nil
+		case c == '\n':
+			p.line++
+		case c == '\\' && quote == '"' && p.pos+1 < len(p.data):
+			p.pos++
+			switch e := p.data[p.pos]; e {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'r':
+				c = '\r'
+			case '$':
+				// Escape substitution
+				r.WriteByte('$')
+				c = '$'
+			case '\n':
+				p.line++
+				c = e
+			default:
+				c = e
+			}
+		}
+		r.WriteByte(c)
+	}
+	return "", p.errorf(line, "unterminated quoted value")
+}
+
+func (p *dotenvParser) skip(chars string) {
+	for p.pos < len(p.data) && strings.IndexByte(chars, p.data[p.pos]) != -1 {
+		if p.data[p.pos] == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+func (p *dotenvParser) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("invalid env file %s:%d: %s", p.file, line, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,200 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Format version of the cache entries. Entries of other versions are ignored.
+const IMAGE_CACHE_VERSION = 1
+
+// On-disk image metadata cache keyed by image name and image ID.
+// An entry is only valid as long as rkt's store contains its image ID under
+// the entry's image name.
+type imageCache struct {
+	dir     string
+	fetchAs *UserGroup
+	// Image names within rkt's store by image ID
+	storeNames map[string]string
+	mutex      *sync.Mutex
+}
+
+type imageCacheEntry struct {
+	Version  int            `json:"version"`
+	Name     string         `json:"name"`
+	ID       string         `json:"id"`
+	Insecure bool           `json:"insecure,omitempty"`
+	Metadata *ImageMetadata `json:"metadata"`
+}
+
+func newImageCache(dir string, fetchAs *UserGroup) *imageCache {
+	return &imageCache{dir, fetchAs, nil, &sync.Mutex{}}
+}
+
+// Returns the cached metadata if its image is still contained in rkt's store
+// under the requested name
+func (c *imageCache) get(name string, insecure bool) (*ImageMetadata, error) {
+	e := c.read(name, insecure)
+	if e == nil {
+		return nil, nil
+	}
+	names, err := c.imageNames()
+	if err != nil {
+		return nil, err
+	}
+	if storeName, ok := names[e.ID]; !ok || !storeImageNameMatches(storeName, name) {
+		return nil, nil
+	}
+	return e.Metadata, nil
+}
+
+// Returns the cached metadata if the name still refers to the image ID
+func (c *imageCache) getByID(name, id string, insecure bool) *ImageMetadata {
+	if e := c.read(name, insecure); e != nil && e.ID == id {
+		return e.Metadata
+	}
+	return nil
+}
+
+// Returns the entry or nil if it does not exist or if it has been fetched
+// insecurely but a verified image is requested
+func (c *imageCache) read(name string, insecure bool) *imageCacheEntry {
+	b, err := ioutil.ReadFile(c.file(name))
+	if err != nil {
+		return nil
+	}
+	e := &imageCacheEntry{}
+	if err = json.Unmarshal(b, e); err != nil || e.Version != IMAGE_CACHE_VERSION || e.Name != name || e.Metadata == nil || e.Insecure && !insecure {
+		return nil
+	}
+	e.Metadata.ID = e.ID
+	return e
+}
+
+func (c *imageCache) put(img *ImageMetadata, insecure bool) error {
+	b, err := json.Marshal(&imageCacheEntry{IMAGE_CACHE_VERSION, img.Name, img.ID, insecure, img})
+	if err != nil {
+		return err
+	}
+	return c.write(c.file(img.Name), b)
+}
+
+// Returns the cached build hash if it has been computed for the same stat hash
+// or "" otherwise. See BuildConfig.statHash()
+func (c *imageCache) getBuildHash(key, stat string) string {
+	b, err := ioutil.ReadFile(c.buildHashFile(key))
+	if err != nil {
+		return ""
+	}
+	if f := strings.Fields(string(b)); len(f) == 2 && f[0] == stat {
+		return f[1]
+	}
+	return ""
+}
+
+func (c *imageCache) putBuildHash(key, stat, hash string) error {
+	return c.write(c.buildHashFile(key), []byte(stat+" "+hash+"\n"))
+}
+
+func (c *imageCache) buildHashFile(key string) string {
+	return filepath.Join(c.dir, key+".build")
+}
+
+func (c *imageCache) write(file string, b []byte) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("Cannot create image cache dir: %s", err)
+	}
+	// Write atomically since multiple processes may share the cache
+	f, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("Cannot write image cache: %s", err)
+	}
+	_, err = f.Write(b)
+	if e := f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Cannot write image cache: %s", err)
+	}
+	return nil
+}
+
+func (c *imageCache) remove(name string) {
+	os.Remove(c.file(name))
+}
+
+func (c *imageCache) file(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:])+".json")
+}
+
+// Returns the names of the images within rkt's store by ID.
+// They are listed only once.
+func (c *imageCache) imageNames() (map[string]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.storeNames == nil {
+		imgs, err := listStoreImages(c.fetchAs)
+		if err != nil {
+			return nil, err
+		}
+		c.storeNames = map[string]string{}
+		for _, img := range imgs {
+			c.storeNames[img.ID] = img.Name
+		}
+	}
+	return c.storeNames, nil
+}
+
+// Returns true if the image name listed by rkt refers to the image name as
+// it is fetched, e.g. registry-1.docker.io/library/alpine:latest to
+// docker://alpine. An image fetched without version matches any version.
+func storeImageNameMatches(storeName, name string) bool {
+	repo, version := splitImageVersion(storeName)
+	expectedRepo, expectedVersion := storeImageName(name)
+	return repo == expectedRepo && (expectedVersion == "" || version == expectedVersion)
+}
+
+// Returns the name and version an image is listed with within rkt's store
+func storeImageName(name string) (repo, version string) {
+	if !isDockerImage(name) {
+		return splitImageVersion(name)
+	}
+	name = name[len("docker://"):]
+	digest := ImageDigest(name)
+	if digest != "" {
+		name = name[:len(name)-len(digest)-1]
+	}
+	repo, version = splitImageVersion(name)
+	if version == "" && digest == "" {
+		version = "latest"
+	}
+	// Apply docker's default registry and namespace
+	if slash := strings.Index(repo, "/"); slash == -1 {
+		repo = "registry-1.docker.io/library/" + repo
+	} else if host := repo[:slash]; !strings.ContainsAny(host, ".:") && host != "localhost" {
+		repo = "registry-1.docker.io/" + repo
+	} else if host == "docker.io" || host == "index.docker.io" {
+		repo = "registry-1.docker.io" + repo[slash:]
+	}
+	return
+}
+
+// Splits the version off the image name (name:version)
+func splitImageVersion(name string) (string, string) {
+	if pos := strings.LastIndex(name, ":"); pos > strings.LastIndex(name, "/") {
+		return name[:pos], name[pos+1:]
+	}
+	return name, ""
+}
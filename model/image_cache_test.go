@@ -0,0 +1,119 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mgoltzsche/rkt-compose/log"
+)
+
+func TestImageCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-compose-image-cache-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newImageCache(dir, nil)
+	c.storeNames = map[string]string{"sha512-1": "registry-1.docker.io/library/alpine:3.6"}
+	img := NewImageMetadata("docker://alpine:3.6", "sha512-1")
+	img.Exec = []string{"/bin/sh"}
+	img.MountPoints["data"] = "/data"
+	img.Ports["http"] = &ImagePort{"tcp", 80}
+	if err = c.put(img, true); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := c.get(img.Name, true); cached == nil || cached.ID != "sha512-1" || cached.MountPoints["data"] != "/data" || cached.Ports["http"].Port != 80 {
+		t.Errorf("get() should return cached image but returned %+v", cached)
+	}
+	if cached, _ := c.get(img.Name, false); cached != nil {
+		t.Errorf("get() should not return insecurely fetched image when verified image requested")
+	}
+	if cached := c.getByID(img.Name, "sha512-2", true); cached != nil {
+		t.Errorf("getByID() should not return image with different ID")
+	}
+	c.storeNames = map[string]string{"sha512-1": "registry-1.docker.io/library/alpine:3.7"}
+	if cached, _ := c.get(img.Name, true); cached != nil {
+		t.Errorf("get() should not return image whose ID is listed under another name")
+	}
+	c.storeNames = map[string]string{}
+	if cached, _ := c.get(img.Name, true); cached != nil {
+		t.Errorf("get() should not return image that is not within the store")
+	}
+	c.storeNames = map[string]string{"sha512-1": "registry-1.docker.io/library/alpine:3.6"}
+	if err = ioutil.WriteFile(c.file(img.Name), []byte(`{"name":"docker://alpine:3.6","id":"sha512-1","insecure":true,"metadata":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := c.get(img.Name, true); cached != nil {
+		t.Errorf("get() should not return entry without format version")
+	}
+	c.remove(img.Name)
+	if cached := c.getByID(img.Name, "sha512-1", true); cached != nil {
+		t.Errorf("getByID() should not return removed image")
+	}
+}
+
+func TestStoreImageNameMatches(t *testing.T) {
+	for _, c := range []struct {
+		storeName string
+		name      string
+		matches   bool
+	}{
+		{"registry-1.docker.io/library/alpine:latest", "docker://alpine", true},
+		{"registry-1.docker.io/library/alpine:3.6", "docker://alpine:3.6", true},
+		{"registry-1.docker.io/library/alpine:3.6", "docker://alpine", false},
+		{"registry-1.docker.io/owncloud/server:10", "docker://owncloud/server:10", true},
+		{"quay.io/coreos/etcd:v3.2", "docker://quay.io/coreos/etcd:v3.2", true},
+		{"localhost:5000/app:1", "docker://localhost:5000/app:1", true},
+		{"registry-1.docker.io/library/alpine:3.6", "docker://alpine@sha256:0123", true},
+		{"registry-1.docker.io/library/busybox:3.6", "docker://alpine@sha256:0123", false},
+		{"coreos.com/etcd:v3.2", "coreos.com/etcd", true},
+		{"coreos.com/etcd:v3.2", "coreos.com/etcd:v3.1", false},
+		{"local/docker-build-dockerfile:0123", "local/docker-build-dockerfile:0123", true},
+	} {
+		if m := storeImageNameMatches(c.storeName, c.name); m != c.matches {
+			t.Errorf("storeImageNameMatches(%q, %q) should return %v", c.storeName, c.name, c.matches)
+		}
+	}
+}
+
+func TestBuildHashCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-compose-build-hash-cache-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctxDir := filepath.Join(dir, "context")
+	if err = os.Mkdir(ctxDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, ctxDir, "Dockerfile", "FROM alpine:3.6\nCOPY app /app\n")
+	writeTestFile(t, ctxDir, "app", "v1")
+	images := NewImages(PULL_NEVER, nil, nil, log.NewNopLogger())
+	images.SetCacheDir(filepath.Join(dir, "cache"))
+	b := &BuildConfig{Dockerfile: filepath.Join(ctxDir, "Dockerfile"), Context: ctxDir}
+	hash, err := images.BuildHash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := assertBuildHash(t, b, ""); hash != expected {
+		t.Errorf("BuildHash() should return %q but returned %q", expected, hash)
+	}
+	// Unchanged files are not read again
+	key, stat, err := b.statHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = images.cache.putBuildHash(key, stat, "cached"); err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ = images.BuildHash(b); hash != "cached" {
+		t.Errorf("BuildHash() should return cached hash but returned %q", hash)
+	}
+	writeTestFile(t, ctxDir, "app", "v2-changed")
+	hash, _ = images.BuildHash(b)
+	if expected := assertBuildHash(t, b, ""); hash != expected {
+		t.Errorf("BuildHash() should return %q after context change but returned %q", expected, hash)
+	}
+}
@@ -12,7 +12,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 )
 
@@ -24,41 +26,181 @@ const (
 	PULL_UPDATE PullPolicy = "update"
 )
 
+// Maps a compose pull_policy onto a PullPolicy.
+// build maps to PULL_NEVER since the image is built before it is fetched.
+// An empty policy results in an empty PullPolicy denoting the default policy.
+func ToPullPolicy(composePolicy string) (PullPolicy, error) {
+	switch composePolicy {
+	case "":
+		return "", nil
+	case "always":
+		return PULL_UPDATE, nil
+	case "missing", "if_not_present":
+		return PULL_NEW, nil
+	case "never", "build":
+		return PULL_NEVER, nil
+	default:
+		return "", fmt.Errorf("Unsupported pull_policy %q. Expected always, missing, never or build", composePolicy)
+	}
+}
+
 type UserGroup struct {
 	Uid uint32
 	Gid uint32
 }
 
+// Fetches and builds images. Safe for concurrent use.
 type Images struct {
 	images     map[string]*ImageMetadata
+	locks      map[string]*sync.Mutex
+	mutex      *sync.Mutex
 	pullPolicy PullPolicy
 	fetchAs    *UserGroup
+	builder    ImageBuilder
+	noCache    bool
+	pull       bool
+	trustKeys  string
+	auth       RegistryAuth
+	cache      *imageCache
 	debug      log.Logger
 }
 
 var toIdRegexp = regexp.MustCompile("[^a-z0-9]+")
 
-func NewImages(pullPolicy PullPolicy, fetchAs *UserGroup, debug log.Logger) *Images {
-	return &Images{map[string]*ImageMetadata{}, pullPolicy, fetchAs, debug}
+func NewImages(pullPolicy PullPolicy, fetchAs *UserGroup, builder ImageBuilder, debug log.Logger) *Images {
+	return &Images{map[string]*ImageMetadata{}, map[string]*sync.Mutex{}, &sync.Mutex{}, pullPolicy, fetchAs, builder, false, false, "", nil, nil, debug}
+}
+
+// Sets whether images are built without cache and whether base images are always pulled
+func (self *Images) SetBuildOptions(noCache, pull bool) {
+	self.noCache = noCache
+	self.pull = pull
+}
+
+// Sets the directory containing the keys signed ACIs are verified with.
+// The directory must have rkt's trustedkeys layout (root.d, prefix.d).
+func (self *Images) SetTrustKeysDir(dir string) {
+	self.trustKeys = dir
+}
+
+// Enables the on-disk image metadata cache within the given directory
+func (self *Images) SetCacheDir(dir string) {
+	self.cache = newImageCache(dir, self.fetchAs)
+}
+
+// Sets the credentials docker images are fetched with
+func (self *Images) SetRegistryAuth(auth RegistryAuth) {
+	self.auth = auth
 }
 
 func (self *Images) Image(name string) (*ImageMetadata, error) {
-	return self.fetchImage(name, self.pullPolicy)
+	return self.FetchImage(name, "", false)
+}
+
+// Fetches the image using the given pull policy or the default policy if empty.
+// Signatures are verified unless insecure is true.
+// Docker images are not signed. Hence they must be pinned by digest (image@sha256:...)
+// which is verified after fetch or be marked as insecure.
+func (self *Images) FetchImage(name string, pullPolicy PullPolicy, insecure bool) (*ImageMetadata, error) {
+	if pullPolicy == "" {
+		pullPolicy = self.pullPolicy
+	}
+	if err := CheckImageVerifiable(name, insecure); err != nil {
+		return nil, err
+	}
+	l := self.lock(name)
+	defer l.Unlock()
+	return self.fetchImage(name, pullPolicy, insecure)
+}
+
+// Returns the policy images are fetched with by default
+func (self *Images) PullPolicy() PullPolicy {
+	return self.pullPolicy
+}
+
+// Locks the image name to avoid concurrent fetches or builds of the same image
+func (self *Images) lock(name string) *sync.Mutex {
+	self.mutex.Lock()
+	l := self.locks[name]
+	if l == nil {
+		l = &sync.Mutex{}
+		self.locks[name] = l
+	}
+	self.mutex.Unlock()
+	l.Lock()
+	return l
 }
 
-func (self *Images) fetchImage(name string, pullPolicy PullPolicy) (r *ImageMetadata, err error) {
-	r = self.images[name]
+func (self *Images) cached(name string) *ImageMetadata {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.images[name]
+}
+
+func (self *Images) fetchImage(name string, pullPolicy PullPolicy, insecure bool) (r *ImageMetadata, err error) {
+	r = self.cached(name)
 	if r != nil {
 		return
 	}
-	r = &ImageMetadata{"", []string{}, "", map[string]string{}, map[string]*ImagePort{}, map[string]string{}}
+	if self.cache != nil && pullPolicy != PULL_UPDATE {
+		// Avoid fetch if image is still within rkt's store
+		r, err = self.cache.get(name, insecure)
+		if err != nil {
+			self.debug.Printf("Ignoring image cache: %s", err)
+		} else if r != nil {
+			self.debug.Printf("Using cached metadata of image %q", name)
+			self.setCached(r)
+			return
+		}
+	}
 	self.debug.Printf("Fetching image %q...", name)
+	id, err := self.rktFetch(name, pullPolicy, insecure)
+	if err != nil {
+		return
+	}
+	if self.cache != nil {
+		if r = self.cache.getByID(name, id, insecure); r != nil {
+			self.setCached(r)
+			return
+		}
+	}
+	r, err = readImageManifest(name, id, insecure)
+	if err != nil {
+		return
+	}
+	if self.cache != nil {
+		if e := self.cache.put(r, insecure); e != nil {
+			self.debug.Printf("Warn: %s", e)
+		}
+	}
+	self.setCached(r)
+	return
+}
+
+func (self *Images) setCached(img *ImageMetadata) {
+	self.mutex.Lock()
+	self.images[img.Name] = img
+	self.mutex.Unlock()
+}
+
+// Fetches the image into rkt's store and returns its ID
+func (self *Images) rktFetch(name string, pullPolicy PullPolicy, insecure bool) (string, error) {
 	insecOpt := ""
-	if strings.Index(name, "docker://") == 0 {
+	// Docker images are verified using their digest after fetch
+	if insecure || isDockerImage(name) {
 		insecOpt = "image"
 	}
+	args := []string{"fetch", "--pull-policy=" + string(pullPolicy), "--insecure-options=" + insecOpt}
+	if self.trustKeys != "" || len(self.auth) > 0 {
+		cfgDir, err := self.writeRktUserConfig()
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(cfgDir)
+		args = append([]string{"--user-config=" + cfgDir}, args...)
+	}
 	var stderr bytes.Buffer
-	c := exec.Command("rkt", "fetch", "--pull-policy="+string(pullPolicy), "--insecure-options="+insecOpt, name)
+	c := exec.Command("rkt", append(args, name)...)
 	if self.fetchAs != nil {
 		c.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: self.fetchAs.Uid, Gid: self.fetchAs.Gid}}
 	}
@@ -69,22 +211,38 @@ func (self *Images) fetchImage(name string, pullPolicy PullPolicy) (r *ImageMeta
 	}
 	out, err := c.Output()
 	if err != nil {
-		return nil, fmt.Errorf("Cannot fetch image %q: %s. %s", name, err, stderr.String())
+		return "", fmt.Errorf("Cannot fetch image %q: %s. %s", name, err, stderr.String())
 	}
-	id := strings.TrimRight(string(out), "\n")
-	c = exec.Command("rkt", "image", "cat-manifest", id)
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// Reads the metadata of the image with the given ID from rkt's store
+func readImageManifest(name, id string, insecure bool) (*ImageMetadata, error) {
+	c := exec.Command("rkt", "image", "cat-manifest", id)
 	c.Stderr = os.Stderr // TODO: set log
-	out, err = c.Output()
+	out, err := c.Output()
 	if err != nil {
 		return nil, fmt.Errorf("Cannot load image manifest %q: %s", name, err)
 	}
+	return parseImageManifest(name, id, out, insecure)
+}
+
+func parseImageManifest(name, id string, manifest []byte, insecure bool) (*ImageMetadata, error) {
 	aci := aciImageMetadata{}
 	app := &aci.App
-	if err := json.Unmarshal(out, &aci); err != nil {
+	if err := json.Unmarshal(manifest, &aci); err != nil {
 		return nil, fmt.Errorf("Cannot unmarshal image manifest: %s", err)
 	}
-	r.Name = name
+	if digest := ImageDigest(name); digest != "" && !insecure {
+		if err := verifyDigest(name, digest, aci.Annotations); err != nil {
+			return nil, err
+		}
+	}
+	r := NewImageMetadata(name, id)
 	r.Exec = app.Exec
+	r.User = app.User
+	r.Group = app.Group
+	r.SupplementaryGIDs = app.SupplementaryGIDs
 	r.WorkingDirectory = app.WorkingDirectory
 	for _, mp := range app.MountPoints {
 		r.MountPoints[mp.Name] = mp.Path
@@ -95,49 +253,156 @@ func (self *Images) fetchImage(name string, pullPolicy PullPolicy) (r *ImageMeta
 	for _, env := range app.Environment {
 		r.Environment[env.Name] = env.Value
 	}
-	self.images[name] = r
-	return
+	for _, i := range app.Isolators {
+		r.Isolators = append(r.Isolators, &ImageIsolator{i.Name, i.Value})
+	}
+	for _, a := range aci.Annotations {
+		r.Annotations[a.Name] = a.Value
+	}
+	for _, l := range aci.Labels {
+		r.Labels[l.Name] = l.Value
+	}
+	return r, nil
 }
 
-func (self *Images) BuildImage(name, dockerFile, contextPath string) (img *ImageMetadata, err error) {
-	img, err = self.fetchImage(name, PULL_NEVER)
-	if err == nil {
-		return
+// Returns the hash of the build configuration. See BuildConfig.Hash().
+// When the cache is enabled the context's files are only read if their
+// sizes or modification times changed since the hash has been cached.
+func (self *Images) BuildHash(build *BuildConfig) (string, error) {
+	if self.cache == nil {
+		return build.Hash()
 	}
-	imgFile := filepath.FromSlash(dockerFile)
-	dockerFileDir := filepath.Dir(imgFile)
-	if contextPath == "" {
-		contextPath = dockerFileDir
-	}
-	self.debug.Printf("Building docker image from %q...", imgFile)
-	c := exec.Command("docker", "build", "-t", name, "--rm", dockerFileDir)
-	c.Dir = contextPath
-	c.Stdout = os.Stdout // TODO: write to log
-	c.Stderr = os.Stderr
-	if err = c.Run(); err != nil {
-		return
+	key, stat, err := build.statHash()
+	if err != nil {
+		return "", err
+	}
+	if hash := self.cache.getBuildHash(key, stat); hash != "" {
+		return hash, nil
+	}
+	hash, err := build.Hash()
+	if err != nil {
+		return "", err
+	}
+	if err = self.cache.putBuildHash(key, stat, hash); err != nil {
+		self.debug.Printf("Warn: %s", err)
+	}
+	return hash, nil
+}
+
+func (self *Images) BuildImage(name string, build *BuildConfig) (img *ImageMetadata, err error) {
+	l := self.lock(name)
+	defer l.Unlock()
+	if !self.noCache && !build.Force {
+		img, err = self.fetchImage(name, PULL_NEVER, false)
+		if err == nil {
+			return
+		}
+	}
+	b := *build
+	b.NoCache = self.noCache
+	b.Pull = self.pull
+	build = &b
+	self.debug.Printf("Building image %q from %q...", name, build.Dockerfile)
+	archiveFile, err := ioutil.TempFile("", "docker-image-")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create temp file: %s", err)
 	}
-	if err = self.importLocalDockerImage(name); err != nil {
+	archiveFile.Close()
+	defer removeFile(archiveFile.Name())
+	if err = self.builder.Build(name, build, archiveFile.Name()); err != nil {
+		return nil, fmt.Errorf("Cannot build image %q: %s", name, err)
+	}
+	if err = self.importDockerArchive(archiveFile.Name()); err != nil {
 		return
 	}
-	img, err = self.fetchImage(name, PULL_NEVER)
-	self.images[name] = img
-	return
+	self.mutex.Lock()
+	delete(self.images, name)
+	self.mutex.Unlock()
+	if self.cache != nil {
+		self.cache.remove(name)
+	}
+	return self.fetchImage(name, PULL_NEVER, false)
 }
 
-func (self *Images) importLocalDockerImage(imgName string) error {
-	dockerImgFile, err := ioutil.TempFile("", "docker-image-")
+// Writes a temporary rkt user config directory containing the trust keys
+// and the registry credentials
+func (self *Images) writeRktUserConfig() (string, error) {
+	dir, err := ioutil.TempDir("", "rkt-user-config-")
 	if err != nil {
-		return fmt.Errorf("Cannot create temp file: %s", err)
+		return "", fmt.Errorf("Cannot create temp rkt config dir: %s", err)
+	}
+	if self.trustKeys != "" {
+		var trustKeys string
+		trustKeys, err = filepath.Abs(self.trustKeys)
+		if err == nil {
+			err = os.Symlink(trustKeys, filepath.Join(dir, "trustedkeys"))
+		}
+	}
+	if err == nil && len(self.auth) > 0 {
+		err = self.auth.writeRktAuthConfig(filepath.Join(dir, "auth.d"))
+	}
+	if err == nil && self.fetchAs != nil {
+		// Make config readable for the user images are fetched with
+		err = chownAll(dir, self.fetchAs)
 	}
-	defer removeFile(dockerImgFile.Name())
-	self.debug.Println("Exporting docker image to file...")
-	out, err := exec.Command("docker", "save", "--output", dockerImgFile.Name(), imgName).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("Cannot export docker image %q: %s. %s", imgName, err, out)
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("Cannot write temp rkt config: %s", err)
+	}
+	return dir, nil
+}
+
+// Changes the owner of the directory and its contents except symlinks
+func chownAll(dir string, owner *UserGroup) error {
+	return filepath.Walk(dir, func(file string, f os.FileInfo, err error) error {
+		if err != nil || f.Mode()&os.ModeSymlink != 0 {
+			return err
+		}
+		return os.Chown(file, int(owner.Uid), int(owner.Gid))
+	})
+}
+
+// Returns an error if the image is neither insecure nor can be verified
+func CheckImageVerifiable(name string, insecure bool) error {
+	if !insecure && isDockerImage(name) && ImageDigest(name) == "" {
+		return fmt.Errorf("Cannot verify docker image %q. Pin it by digest (image@sha256:...) or allow it using x-insecure: true", name)
+	}
+	return nil
+}
+
+func isDockerImage(name string) bool {
+	return strings.Index(name, "docker://") == 0
+}
+
+// Returns the digest an image name is pinned with (image@sha256:...) or an empty string
+func ImageDigest(name string) string {
+	if pos := strings.LastIndex(name, "@"); pos != -1 && strings.Index(name[pos+1:], ":") != -1 {
+		return name[pos+1:]
+	}
+	return ""
+}
+
+// Verifies the manifest digest docker2aci annotated the fetched image with
+func verifyDigest(name, digest string, annotations []*aciAnnotation) error {
+	for _, a := range annotations {
+		if a.Name == "appc.io/docker/manifesthash" {
+			if a.Value != digest {
+				return fmt.Errorf("Image %q digest mismatch: %s", name, a.Value)
+			}
+			return nil
+		}
 	}
+	return fmt.Errorf("Cannot verify digest of image %q: no manifest hash annotation found", name)
+}
+
+// Converts a docker archive to ACI and imports it into the rkt image store
+func (self *Images) importDockerArchive(archiveFile string) error {
 	self.debug.Println("Converting docker image to ACI...")
-	tmpDir := os.TempDir()
+	tmpDir, err := ioutil.TempDir("", "aci-")
+	if err != nil {
+		return fmt.Errorf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
 	d2aCfg := docker2aci.FileConfig{
 		CommonConfig: docker2aci.CommonConfig{
 			Squash:      true,
@@ -149,28 +414,29 @@ func (self *Images) importLocalDockerImage(imgName string) error {
 		},
 		DockerURL: "",
 	}
-	aciLayerPaths, err := docker2aci.ConvertSavedFile(dockerImgFile.Name(), d2aCfg)
+	aciLayerPaths, err := docker2aci.ConvertSavedFile(archiveFile, d2aCfg)
 	if err != nil {
 		return fmt.Errorf("Cannot convert docker image to ACI: %s", err)
 	}
 	if len(aciLayerPaths) < 1 {
 		return fmt.Errorf("No ACI files returned by docker2aci")
 	}
-	for _, f := range aciLayerPaths {
-		defer removeFile(f)
+	if self.fetchAs != nil {
+		// Make ACI readable for the user images are fetched with
+		if err = chownAll(tmpDir, self.fetchAs); err != nil {
+			return fmt.Errorf("Cannot change converted ACI's owner: %s", err)
+		}
 	}
 	self.debug.Println("Importing ACI file...")
 	var stderr bytes.Buffer
-	c := exec.Command("rkt", "prepare", "--quiet=true", "--insecure-options=image", aciLayerPaths[0])
+	c := exec.Command("rkt", "fetch", "--insecure-options=image", aciLayerPaths[0])
+	if self.fetchAs != nil {
+		c.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: self.fetchAs.Uid, Gid: self.fetchAs.Gid}}
+	}
 	c.Stderr = &stderr
-	out, err = c.Output()
-	if err != nil {
+	if _, err = c.Output(); err != nil {
 		return fmt.Errorf("Cannot import converted docker image: %s. %s", err, stderr.String())
 	}
-	cId := strings.TrimRight(string(out), "\n")
-	if e := exec.Command("rkt", "rm", cId).Run(); e != nil {
-		return fmt.Errorf("Cannot remove rkt pod %q used to import converted docker image: %s", cId, e)
-	}
 	return nil
 }
 
@@ -186,12 +452,51 @@ func removeFile(file string) {
 }
 
 type ImageMetadata struct {
-	Name             string
-	Exec             []string
-	WorkingDirectory string
-	MountPoints      map[string]string
-	Ports            map[string]*ImagePort
-	Environment      map[string]string
+	Name              string
+	ID                string
+	Exec              []string
+	User              string
+	Group             string
+	SupplementaryGIDs []int
+	WorkingDirectory  string
+	MountPoints       map[string]string
+	Ports             map[string]*ImagePort
+	Environment       map[string]string
+	Isolators         []*ImageIsolator
+	Annotations       map[string]string
+	Labels            map[string]string
+}
+
+func NewImageMetadata(name, id string) *ImageMetadata {
+	return &ImageMetadata{
+		Name:        name,
+		ID:          id,
+		Exec:        []string{},
+		MountPoints: map[string]string{},
+		Ports:       map[string]*ImagePort{},
+		Environment: map[string]string{},
+		Isolators:   []*ImageIsolator{},
+		Annotations: map[string]string{},
+		Labels:      map[string]string{},
+	}
+}
+
+// Returns the exposed ports of the given protocol sorted by port
+func (img *ImageMetadata) ExposedPorts(protocol string) []uint16 {
+	r := []uint16{}
+	for _, p := range img.Ports {
+		if p.Protocol == protocol {
+			r = append(r, p.Port)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i] < r[j] })
+	return r
+}
+
+// Resource isolator such as resource/memory or os/linux/capabilities-retain-set
+type ImageIsolator struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
 }
 
 type ImagePort struct {
@@ -200,16 +505,32 @@ type ImagePort struct {
 }
 
 type aciImageMetadata struct {
-	Name string `json:"name"`
-	App  aciApp `json:"app"`
+	Name        string           `json:"name"`
+	Labels      []*aciAnnotation `json:"labels"`
+	App         aciApp           `json:"app"`
+	Annotations []*aciAnnotation `json:"annotations"`
+}
+
+type aciAnnotation struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
 type aciApp struct {
-	Exec             []string         `json:"exec"`
-	WorkingDirectory string           `json:"workingDirectory"`
-	MountPoints      []*aciMountPoint `json:"mountPoints"`
-	Ports            []*aciImagePort  `json:"ports"`
-	Environment      []*aciEnvVar     `json:"environment"`
+	Exec              []string         `json:"exec"`
+	User              string           `json:"user"`
+	Group             string           `json:"group"`
+	SupplementaryGIDs []int            `json:"supplementaryGIDs"`
+	WorkingDirectory  string           `json:"workingDirectory"`
+	MountPoints       []*aciMountPoint `json:"mountPoints"`
+	Ports             []*aciImagePort  `json:"ports"`
+	Environment       []*aciEnvVar     `json:"environment"`
+	Isolators         []*aciIsolator   `json:"isolators"`
+}
+
+type aciIsolator struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
 }
 
 type aciImagePort struct {
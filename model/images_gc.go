@@ -0,0 +1,146 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Image within rkt's store
+type StoreImage struct {
+	ID   string
+	Name string
+}
+
+// Removes the images that are not referenced by the provided image names or IDs.
+// Only locally built images (local/...) and images known to the metadata
+// cache are considered. Hence images fetched by other tools are retained.
+// Returns the removed images. If dryRun is true no image is removed.
+func (self *Images) GarbageCollect(referencedNames, referencedIDs []string, dryRun bool) ([]*StoreImage, error) {
+	referenced := map[string]bool{}
+	for _, id := range referencedIDs {
+		referenced[id] = true
+	}
+	for _, name := range referencedNames {
+		// Resolve the ID of images within the store only
+		if img, err := self.FetchImage(name, PULL_NEVER, true); err == nil {
+			referenced[img.ID] = true
+		} else {
+			self.debug.Printf("Referenced image %q not in store: %s", name, err)
+		}
+	}
+	known := map[string]bool{}
+	if self.cache != nil {
+		for _, e := range self.cache.entries() {
+			known[e.ID] = true
+		}
+	}
+	imgs, err := listStoreImages(self.fetchAs)
+	if err != nil {
+		return nil, err
+	}
+	garbage := []*StoreImage{}
+	for _, img := range imgs {
+		if !referenced[img.ID] && (strings.HasPrefix(img.Name, "local/") || known[img.ID]) {
+			garbage = append(garbage, img)
+		}
+	}
+	if dryRun || len(garbage) == 0 {
+		return garbage, nil
+	}
+	removed := []*StoreImage{}
+	msgs := []string{}
+	for _, img := range garbage {
+		self.debug.Printf("Removing image %s (%s)", img.ID, img.Name)
+		var stderr bytes.Buffer
+		c := exec.Command("rkt", "image", "rm", img.ID)
+		if self.fetchAs != nil {
+			c.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: self.fetchAs.Uid, Gid: self.fetchAs.Gid}}
+		}
+		c.Stderr = &stderr
+		if err = c.Run(); err != nil {
+			msgs = append(msgs, fmt.Sprintf("Cannot remove image %s (%s): %s. %s", img.ID, img.Name, err, strings.TrimSpace(stderr.String())))
+			continue
+		}
+		removed = append(removed, img)
+		if self.cache != nil {
+			self.cache.removeID(img.ID)
+		}
+	}
+	if len(msgs) > 0 {
+		err = fmt.Errorf("%s", strings.Join(msgs, "\n"))
+	}
+	return removed, err
+}
+
+// Returns the valid cache entries
+func (c *imageCache) entries() []*imageCacheEntry {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil
+	}
+	r := []*imageCacheEntry{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") {
+			e := &imageCacheEntry{}
+			b, err := ioutil.ReadFile(filepath.Join(c.dir, f.Name()))
+			if err == nil && json.Unmarshal(b, e) == nil && e.ID != "" && c.file(e.Name) == filepath.Join(c.dir, f.Name()) {
+				r = append(r, e)
+			}
+		}
+	}
+	return r
+}
+
+// Removes the cache entries of the given image ID
+func (c *imageCache) removeID(id string) {
+	for _, e := range c.entries() {
+		if e.ID == id {
+			c.remove(e.Name)
+		}
+	}
+}
+
+// Lists the images within rkt's store sorted by name
+func listStoreImages(as *UserGroup) ([]*StoreImage, error) {
+	var stderr bytes.Buffer
+	c := exec.Command("rkt", "image", "list", "--fields=id,name", "--full", "--no-legend")
+	if as != nil {
+		c.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: as.Uid, Gid: as.Gid}}
+	}
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list images: %s. %s", err, stderr.String())
+	}
+	r, err := parseImageList(out)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse image list: %s", err)
+	}
+	return r, nil
+}
+
+func parseImageList(out []byte) ([]*StoreImage, error) {
+	r := []*StoreImage{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) == 0 {
+			continue
+		}
+		img := &StoreImage{ID: f[0]}
+		if len(f) > 1 {
+			img.Name = f[1]
+		}
+		r = append(r, img)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name || r[i].Name == r[j].Name && r[i].ID < r[j].ID })
+	return r, scanner.Err()
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImageList(t *testing.T) {
+	out := "sha512-b\tregistry-1.docker.io/library/alpine:3.6\nsha512-c\tlocal/docker-build-dockerfile:0123456789abcdef\n\nsha512-a\tlocal/docker-build-dockerfile:fedcba9876543210\n"
+	imgs, err := parseImageList([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := []string{}
+	for _, img := range imgs {
+		actual = append(actual, img.ID+" "+img.Name)
+	}
+	expected := "sha512-c local/docker-build-dockerfile:0123456789abcdef, sha512-a local/docker-build-dockerfile:fedcba9876543210, sha512-b registry-1.docker.io/library/alpine:3.6"
+	if a := strings.Join(actual, ", "); a != expected {
+		t.Errorf("expected images %s but was %s", expected, a)
+	}
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseImageManifest(t *testing.T) {
+	b, err := ioutil.ReadFile("../test-resources/example-aci-image-manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := parseImageManifest("docker://owncloud", "sha512-1", b, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := fmt.Sprintf("%s %s %v %s:%s %s %s %v %s %s %s", img.Name, img.ID, img.Exec, img.User, img.Group, img.WorkingDirectory,
+		img.MountPoints["volume-var-www-html"], img.ExposedPorts("tcp"), img.Environment["PHP_VERSION"], img.Labels["version"], img.Annotations["appc.io/docker/repository"])
+	expected := "docker://owncloud sha512-1 [/entrypoint.sh apache2-foreground] 0:0 /var/www/html /var/www/html [80] 5.6.29 latest library/owncloud"
+	if actual != expected {
+		t.Errorf("expected image metadata\n  %s\nbut was\n  %s", expected, actual)
+	}
+}
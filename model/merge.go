@@ -0,0 +1,330 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Loads the first descriptor file and merges the following files into it in order.
+// If a single file is provided and a docker-compose.override.yml file exists
+// next to it the override file is merged.
+// Like in docker compose relative paths within all files are resolved relative
+// to the first file's directory or, if set, the project directory.
+func (self *Descriptors) MergedDescriptor(files ...string) (r *PodDescriptor, err error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No descriptor file provided")
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("model: %s", e)
+		}
+	}()
+	resolved := make([]string, len(files))
+	for i, f := range files {
+		f, err = filepath.Abs(f)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid descriptor file path: %s", err)
+		}
+		resolved[i] = resolveDescriptorFile(path.Clean(filepath.ToSlash(f)))
+	}
+	files = resolved
+	if len(files) == 1 {
+		if override := overrideFile(files[0]); override != "" {
+			files = append(files, override)
+		}
+	}
+	if len(files) == 1 && self.projectDir == "" {
+		return self.loadDescriptor(files[0]), nil
+	}
+	r = NewPodDescriptor()
+	r.File = files[0]
+	for _, f := range files {
+		self.mergeFile(r, f)
+	}
+	if self.projectDir != "" {
+		rebasePaths(r, self.projectDir)
+	}
+	validate(r)
+	return
+}
+
+func (self *Descriptors) mergeFile(r *PodDescriptor, file string) {
+	defer func() {
+		if e := recover(); e != nil {
+			panic(fmt.Sprintf("%q: %s", file, e))
+		}
+	}()
+	mergeDescriptor(r, self.readDescriptor(file))
+}
+
+// Makes the descriptor's relative paths absolute using the given directory
+func rebasePaths(d *PodDescriptor, dir string) {
+	abs := func(p string) string {
+		if p == "" || path.IsAbs(p) {
+			return p
+		}
+		return path.Join(dir, p)
+	}
+	for _, v := range d.Volumes {
+		v.Source = abs(v.Source)
+	}
+	for _, s := range d.Services {
+		if s.Extends != nil {
+			s.Extends.File = abs(s.Extends.File)
+		}
+		if s.Build != nil && s.Build.Context != "" {
+			s.Build.Context = abs(s.Build.Context)
+		}
+		for i, f := range s.EnvFile {
+			s.EnvFile[i] = abs(f)
+		}
+		for target, src := range s.Mounts {
+			// Named volumes are not paths
+			if src == "." || strings.HasPrefix(src, "./") || strings.HasPrefix(src, "../") {
+				s.Mounts[target] = abs(src)
+			}
+		}
+	}
+}
+
+// Returns the docker-compose.override.yml file next to a compose file or an empty string
+func overrideFile(file string) string {
+	ext := path.Ext(file)
+	if ext != ".yml" && ext != ".yaml" {
+		return ""
+	}
+	dir := path.Dir(file)
+	for _, f := range []string{"docker-compose.override.yml", "docker-compose.override.yaml"} {
+		f = path.Join(dir, f)
+		if f != file && fileExists(f) && !isDirectory(f) {
+			return f
+		}
+	}
+	return ""
+}
+
+// Merges src into dst using docker compose's merge rules:
+// Maps (also within build and healthcheck) are merged, single values and
+// command/entrypoint are replaced and ports are appended unless the target
+// port is already bound.
+func mergeDescriptor(dst, src *PodDescriptor) {
+	if src.Name != "" {
+		dst.Name = src.Name
+	}
+	if len(src.Net) > 0 {
+		dst.Net = src.Net
+	}
+	if len(src.Dns) > 0 {
+		dst.Dns = src.Dns
+	}
+	if len(src.DnsSearch) > 0 {
+		dst.DnsSearch = src.DnsSearch
+	}
+	if src.Hostname != "" {
+		dst.Hostname = src.Hostname
+	}
+	if src.Domainname != "" {
+		dst.Domainname = src.Domainname
+	}
+	if src.DisableHostsInjection != "" {
+		dst.DisableHostsInjection = src.DisableHostsInjection
+	}
+	if src.SharedKeysOverrideAllowed != "" {
+		dst.SharedKeysOverrideAllowed = src.SharedKeysOverrideAllowed
+	}
+	if src.StopGracePeriod != "" {
+		dst.StopGracePeriod = src.StopGracePeriod
+	}
+	dst.Environment = mergeStringMap(dst.Environment, src.Environment)
+	dst.SharedKeys = mergeStringMap(dst.SharedKeys, src.SharedKeys)
+	for k, v := range src.Volumes {
+		dst.Volumes[k] = v
+	}
+	for k, v := range src.Networks {
+		dst.Networks[k] = v
+	}
+	for k, v := range src.Services {
+		s := dst.Services[k]
+		if s == nil {
+			s = &ServiceDescriptor{}
+			dst.Services[k] = s
+		}
+		mergeService(s, v)
+	}
+}
+
+func mergeService(dst, src *ServiceDescriptor) {
+	if src.Extends != nil {
+		dst.Extends = src.Extends
+	}
+	if src.Image != "" {
+		dst.Image = src.Image
+	}
+	if src.Build != nil {
+		dst.Build = mergeBuild(dst.Build, src.Build)
+	}
+	if src.PullPolicy != "" {
+		dst.PullPolicy = src.PullPolicy
+	}
+	if src.Insecure != "" {
+		dst.Insecure = src.Insecure
+	}
+	if len(src.Entrypoint) > 0 || dst.Entrypoint == nil {
+		dst.Entrypoint = src.Entrypoint
+	}
+	if len(src.Command) > 0 || dst.Command == nil {
+		dst.Command = src.Command
+	}
+	if src.WorkingDir != "" {
+		dst.WorkingDir = src.WorkingDir
+	}
+	if src.User != "" {
+		dst.User = src.User
+	}
+	if src.Tty != "" {
+		dst.Tty = src.Tty
+	}
+	if src.StdinOpen != "" {
+		dst.StdinOpen = src.StdinOpen
+	}
+	if src.Init != "" {
+		dst.Init = src.Init
+	}
+	if src.StopSignal != "" {
+		dst.StopSignal = src.StopSignal
+	}
+	if src.StopGracePeriod != "" {
+		dst.StopGracePeriod = src.StopGracePeriod
+	}
+	dst.EnvFile = appendUnique(dst.EnvFile, src.EnvFile)
+	dst.Environment = mergeStringMap(dst.Environment, src.Environment)
+	if src.HealthCheck != nil {
+		dst.HealthCheck = mergeHealthCheck(dst.HealthCheck, src.HealthCheck)
+	}
+	if src.OnUnhealthy != nil {
+		dst.OnUnhealthy = src.OnUnhealthy
+	}
+	ports := make([]*PortBindingDescriptor, len(dst.Ports), len(dst.Ports)+len(src.Ports))
+	copy(ports, dst.Ports)
+	for _, p := range src.Ports {
+		replaced := false
+		for i, ex := range ports {
+			if ex.Target == p.Target && strings.ToLower(ex.Protocol) == strings.ToLower(p.Protocol) {
+				ports[i] = p
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			ports = append(ports, p)
+		}
+	}
+	dst.Ports = ports
+	dst.Mounts = mergeStringMap(dst.Mounts, src.Mounts)
+	dst.DependsOn = appendUnique(dst.DependsOn, src.DependsOn)
+	if len(src.Networks) > 0 {
+		networks := map[string]*ServiceNetworkDescriptor{}
+		for k, v := range dst.Networks {
+			networks[k] = v
+		}
+		for k, v := range src.Networks {
+			networks[k] = v
+		}
+		dst.Networks = networks
+	}
+	if len(src.Profiles) > 0 {
+		dst.Profiles = src.Profiles
+	}
+	if src.Pod != "" {
+		dst.Pod = src.Pod
+	}
+}
+
+func mergeBuild(dst, src *ServiceBuildDescriptor) *ServiceBuildDescriptor {
+	if dst == nil {
+		return src
+	}
+	r := *dst
+	if src.Context != "" {
+		r.Context = src.Context
+	}
+	if src.Dockerfile != "" {
+		r.Dockerfile = src.Dockerfile
+	}
+	if src.Target != "" {
+		r.Target = src.Target
+	}
+	if src.Network != "" {
+		r.Network = src.Network
+	}
+	if src.ShmSize != "" {
+		r.ShmSize = src.ShmSize
+	}
+	r.Args = mergeStringMap(dst.Args, src.Args)
+	r.Labels = mergeStringMap(dst.Labels, src.Labels)
+	r.CacheFrom = appendUnique(dst.CacheFrom, src.CacheFrom)
+	return &r
+}
+
+// Merges the health check options. A test (or probe extension) replaces the
+// one declared before since a check has a single indicator.
+func mergeHealthCheck(dst, src *HealthCheckDescriptor) *HealthCheckDescriptor {
+	if dst == nil {
+		return src
+	}
+	r := *dst
+	if len(src.Command) > 0 || src.Http != "" || src.Tcp != "" || src.Grpc != "" {
+		r.Command = src.Command
+		r.Http = src.Http
+		r.Tcp = src.Tcp
+		r.Grpc = src.Grpc
+		r.GrpcService = src.GrpcService
+	}
+	if src.Interval != "" {
+		r.Interval = src.Interval
+	}
+	if src.Timeout != "" {
+		r.Timeout = src.Timeout
+	}
+	if src.StartPeriod != "" {
+		r.StartPeriod = src.StartPeriod
+	}
+	if src.Retries != "" {
+		r.Retries = src.Retries
+	}
+	if src.Disable != "" {
+		r.Disable = src.Disable
+	}
+	return &r
+}
+
+func mergeStringMap(dst, src map[string]string) map[string]string {
+	r := make(map[string]string, len(dst)+len(src))
+	for k, v := range dst {
+		r[k] = v
+	}
+	for k, v := range src {
+		r[k] = v
+	}
+	return r
+}
+
+func appendUnique(dst, src []string) []string {
+	r := make([]string, len(dst), len(dst)+len(src))
+	copy(r, dst)
+	for _, v := range src {
+		found := false
+		for _, e := range r {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r = append(r, v)
+		}
+	}
+	return r
+}
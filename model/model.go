@@ -11,6 +11,7 @@ func NewPodDescriptor() *PodDescriptor {
 	r.Version = 1
 	r.Services = map[string]*ServiceDescriptor{}
 	r.Volumes = map[string]*VolumeDescriptor{}
+	r.Networks = map[string]*NetworkDescriptor{}
 	r.Net = []string{}
 	r.Dns = []string{}
 	r.DnsSearch = []string{}
@@ -30,29 +31,49 @@ type PodDescriptor struct {
 	Environment               map[string]string             `json:"environment,omitempty"`
 	Services                  map[string]*ServiceDescriptor `json:"services"`
 	Volumes                   map[string]*VolumeDescriptor  `json:"volumes,omitempty"`
+	Networks                  map[string]*NetworkDescriptor `json:"networks,omitempty"`
 	SharedKeys                map[string]string             `json:"shared_keys,omitempty"`
 	SharedKeysOverrideAllowed BoolVal                       `json:"shared_keys_overridable,omitempty"`
 	StopGracePeriod           string                        `json:"stop_grace_period,omitempty"`
 }
 
 type ServiceDescriptor struct {
-	Extends     *ServiceDescriptorExtension `json:"extends,omitempty"`
-	Image       string                      `json:"image,omitempty"`
-	Build       *ServiceBuildDescriptor     `json:"build,omitempty"`
-	Entrypoint  []string                    `json:"entrypoint,omitempty"`
-	Command     []string                    `json:"command,omitempty"`
-	EnvFile     []string                    `json:"env_file,omitempty"`
-	Environment map[string]string           `json:"environment,omitempty"`
-	HealthCheck *HealthCheckDescriptor      `json:"healthcheck,omitempty"`
-	Ports       []*PortBindingDescriptor    `json:"ports,omitempty"`
-	Mounts      map[string]string           `json:"mounts,omitempty"`
+	Extends         *ServiceDescriptorExtension          `json:"extends,omitempty"`
+	Image           string                               `json:"image,omitempty"`
+	Build           *ServiceBuildDescriptor              `json:"build,omitempty"`
+	PullPolicy      string                               `json:"pull_policy,omitempty"`
+	Insecure        BoolVal                              `json:"insecure,omitempty"`
+	Entrypoint      []string                             `json:"entrypoint,omitempty"`
+	Command         []string                             `json:"command,omitempty"`
+	WorkingDir      string                               `json:"working_dir,omitempty"`
+	User            string                               `json:"user,omitempty"`
+	Tty             BoolVal                              `json:"tty,omitempty"`
+	StdinOpen       BoolVal                              `json:"stdin_open,omitempty"`
+	Init            BoolVal                              `json:"init,omitempty"`
+	StopSignal      string                               `json:"stop_signal,omitempty"`
+	StopGracePeriod string                               `json:"stop_grace_period,omitempty"`
+	EnvFile         []string                             `json:"env_file,omitempty"`
+	Environment     map[string]string                    `json:"environment,omitempty"`
+	HealthCheck     *HealthCheckDescriptor               `json:"healthcheck,omitempty"`
+	OnUnhealthy     *UnhealthyPolicyDescriptor           `json:"on_unhealthy,omitempty"`
+	Ports           []*PortBindingDescriptor             `json:"ports,omitempty"`
+	Mounts          map[string]string                    `json:"mounts,omitempty"`
+	DependsOn       []string                             `json:"depends_on,omitempty"`
+	Networks        map[string]*ServiceNetworkDescriptor `json:"networks,omitempty"`
+	Profiles        []string                             `json:"profiles,omitempty"`
+	// Pod the service is run in when run as project. Defaults to the service name
+	Pod string `json:"pod,omitempty"`
 }
 
 type ServiceBuildDescriptor struct {
-	Context    string `json:"context,omitempty"`
-	Dockerfile string `json:"dockerfile,omitempty"`
-	// TODO: use args
-	Args map[string]string `json:"args,omitempty"`
+	Context    string            `json:"context,omitempty"`
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Args       map[string]string `json:"args,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	CacheFrom  []string          `json:"cache_from,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Network    string            `json:"network,omitempty"`
+	ShmSize    string            `json:"shm_size,omitempty"`
 }
 
 type ServiceDescriptorExtension struct {
@@ -61,7 +82,7 @@ type ServiceDescriptorExtension struct {
 }
 
 type PortBindingDescriptor struct {
-	Target    NumberVal `json:"target"`
+	Target    NumberVal `json:"target,omitempty"`
 	Published NumberVal `json:"published,omitempty"`
 	IP        string    `json:"ip,omitempty"`
 	Protocol  string    `json:"protocol,omitempty"`
@@ -73,13 +94,38 @@ type VolumeDescriptor struct {
 	Readonly BoolVal `json:"readonly,omitempty"`
 }
 
+type NetworkDescriptor struct {
+	// CNI network name. Defaults to the network's key prefixed with the project name
+	Name       string            `json:"name,omitempty"`
+	Driver     string            `json:"driver,omitempty"`
+	DriverOpts map[string]string `json:"driver_opts,omitempty"`
+	Subnet     string            `json:"subnet,omitempty"`
+	Gateway    string            `json:"gateway,omitempty"`
+	// Refers to a network configured in rkt's net.d directory
+	External BoolVal `json:"external,omitempty"`
+}
+
+type ServiceNetworkDescriptor struct {
+	IPv4Address string `json:"ipv4_address,omitempty"`
+}
+
 type HealthCheckDescriptor struct {
-	Command  []string  `json:"cmd,omitempty"`
-	Http     string    `json:"http,omitempty"`
-	Interval string    `json:"interval,omitempty"`
-	Timeout  string    `json:"timeout,omitempty"`
-	Retries  NumberVal `json:"retries,omitempty"`
-	Disable  BoolVal   `json:"disable,omitempty"`
+	Command     []string  `json:"cmd,omitempty"`
+	Http        string    `json:"http,omitempty"`
+	Tcp         NumberVal `json:"tcp,omitempty"`
+	Grpc        NumberVal `json:"grpc,omitempty"`
+	GrpcService string    `json:"grpc_service,omitempty"`
+	Interval    string    `json:"interval,omitempty"`
+	Timeout     string    `json:"timeout,omitempty"`
+	StartPeriod string    `json:"start_period,omitempty"`
+	Retries     NumberVal `json:"retries,omitempty"`
+	Disable     BoolVal   `json:"disable,omitempty"`
+}
+
+// Action performed when a service's health check stays critical
+type UnhealthyPolicyDescriptor struct {
+	Action    string `json:"action"`
+	Threshold string `json:"threshold,omitempty"`
 }
 
 func (d *PodDescriptor) JSON() string {
@@ -9,6 +9,7 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,10 +19,25 @@ var idRegexp = regexp.MustCompile("^[a-z0-9\\-]+$")
 type Descriptors struct {
 	descriptors          map[string]*PodDescriptor
 	defaultVolumeBaseDir string
+	substitutes          *Substitutes
+	projectDir           string
 }
 
-func NewDescriptors(defaultVolumeBaseDir string) *Descriptors {
-	return &Descriptors{map[string]*PodDescriptor{}, defaultVolumeBaseDir}
+// Creates a descriptor loader.
+// Variables within descriptor files are substituted unless substitutes is nil.
+func NewDescriptors(defaultVolumeBaseDir string, substitutes *Substitutes) *Descriptors {
+	return &Descriptors{map[string]*PodDescriptor{}, defaultVolumeBaseDir, substitutes, ""}
+}
+
+// Sets the directory relative paths within merged descriptors are resolved
+// against instead of the first file's directory
+func (self *Descriptors) SetProjectDirectory(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("Invalid project directory: %s", err)
+	}
+	self.projectDir = filepath.ToSlash(dir)
+	return nil
 }
 
 func (self *Descriptors) Descriptor(file string) (r *PodDescriptor, err error) {
@@ -48,46 +64,53 @@ func (self *Descriptors) loadDescriptor(filePath string) (r *PodDescriptor) {
 	}()
 	r = self.descriptors[filePath]
 	if r == nil {
-		filePath = resolveDescriptorFile(filePath)
-		fileExt := filepath.Ext(filePath)
-		r = NewPodDescriptor()
-		if fileExt == ".yml" || fileExt == ".yaml" {
-			self.readDockerCompose(filePath, r)
-		} else {
-			readPodJson(filePath, r)
-		}
-		r.File = filePath
-		// Set defaults
-
-		for _, v := range r.Services {
-			if v.Entrypoint == nil {
-				v.Entrypoint = []string{}
-			}
-			if v.Command == nil {
-				v.Command = []string{}
-			}
-			if v.EnvFile == nil {
-				v.EnvFile = []string{}
-			}
-			if v.Environment == nil {
-				v.Environment = map[string]string{}
-			}
-			if v.Ports == nil {
-				v.Ports = []*PortBindingDescriptor{}
-			}
-			if v.Mounts == nil {
-				v.Mounts = map[string]string{}
-			}
-		}
-		if r.SharedKeys == nil {
-			r.SharedKeys = map[string]string{}
-		}
+		r = self.readDescriptor(resolveDescriptorFile(filePath))
 		validate(r)
 		self.descriptors[filePath] = r
 	}
 	return r
 }
 
+// Reads a descriptor file without validating it
+func (self *Descriptors) readDescriptor(filePath string) (r *PodDescriptor) {
+	fileExt := filepath.Ext(filePath)
+	r = NewPodDescriptor()
+	if fileExt == ".yml" || fileExt == ".yaml" {
+		self.readDockerCompose(filePath, r)
+	} else {
+		self.readPodJson(filePath, r)
+	}
+	r.File = filePath
+	setDefaults(r)
+	return
+}
+
+func setDefaults(r *PodDescriptor) {
+	for _, v := range r.Services {
+		if v.Entrypoint == nil {
+			v.Entrypoint = []string{}
+		}
+		if v.Command == nil {
+			v.Command = []string{}
+		}
+		if v.EnvFile == nil {
+			v.EnvFile = []string{}
+		}
+		if v.Environment == nil {
+			v.Environment = map[string]string{}
+		}
+		if v.Ports == nil {
+			v.Ports = []*PortBindingDescriptor{}
+		}
+		if v.Mounts == nil {
+			v.Mounts = map[string]string{}
+		}
+	}
+	if r.SharedKeys == nil {
+		r.SharedKeys = map[string]string{}
+	}
+}
+
 func validate(d *PodDescriptor) {
 	assertTrue(d.Services != nil && len(d.Services) > 0, "empty", ".services")
 	for k, v := range d.Services {
@@ -96,6 +119,13 @@ func validate(d *PodDescriptor) {
 		assertTrue(len(v.Image) > 0 || v.Build != nil || v.Extends != nil, "empty", kPath+".{image|build|extends}")
 		assertTrue(v.Build == nil || len(v.Build.Context) > 0, "empty", kPath+".build.context")
 		assertTrue(v.Extends == nil || len(v.Extends.Service) > 0, "empty", kPath+".extends.service")
+		assertTrue(v.Pod == "" || idRegexp.MatchString(v.Pod), "invalid pod name", kPath+".pod")
+		if h := v.HealthCheck; h != nil {
+			assertTrue(len(h.Command) > 0 || h.Http != "" || h.Tcp != "" || h.Grpc != "", "undefined health test command", kPath+".healthcheck.test")
+		}
+		for _, dep := range v.DependsOn {
+			assertTrue(d.Services[dep] != nil, "undefined service "+dep, kPath+".depends_on")
+		}
 	}
 	for k, v := range d.Volumes {
 		assertTrue(len(v.Source) > 0, "empty", ".volumes."+k+".source")
@@ -118,20 +148,80 @@ func resolveDescriptorFile(file string) string {
 	return file
 }
 
-func readPodJson(file string, r *PodDescriptor) {
-	bytes := readFile(file)
-	err := json.Unmarshal(bytes, r)
+func (self *Descriptors) readPodJson(file string, r *PodDescriptor) {
+	var tree interface{}
+	err := json.Unmarshal(readFile(file), &tree)
+	panicOnError(err)
+	b, err := json.Marshal(self.interpolate(tree))
+	panicOnError(err)
+	err = json.Unmarshal(b, r)
 	panicOnError(err)
 }
 
 func (self *Descriptors) readDockerCompose(file string, r *PodDescriptor) {
+	var tree interface{}
+	err := yaml.Unmarshal(readFile(file), &tree)
+	panicOnError(err)
+	b, err := yaml.Marshal(self.interpolate(tree))
+	panicOnError(err)
 	c := dockerCompose{}
-	bytes := readFile(file)
-	err := yaml.Unmarshal(bytes, &c)
+	err = yaml.Unmarshal(b, &c)
 	panicOnError(err)
 	self.transformDockerCompose(&c, r)
 }
 
+// Substitutes variables within all string values and map keys of the raw descriptor tree
+func (self *Descriptors) interpolate(tree interface{}) interface{} {
+	if self.substitutes == nil {
+		return tree
+	}
+	tree = self.interpolateValue(tree, "")
+	panicOnError(self.substitutes.Err())
+	return tree
+}
+
+func (self *Descriptors) interpolateValue(v interface{}, path string) interface{} {
+	switch t := v.(type) {
+	case string:
+		return self.substitutes.SubstituteAt(t, path)
+	case map[interface{}]interface{}:
+		r := make(map[interface{}]interface{}, len(t))
+		for k, e := range t {
+			key := k
+			if str, ok := k.(string); ok {
+				key = self.substitutes.SubstituteAt(str, joinPath(path, str))
+			}
+			if _, dup := r[key]; dup {
+				self.substitutes.fail(joinPath(path, fmt.Sprintf("%v", key)), "duplicate key after substitution")
+			}
+			r[key] = self.interpolateValue(e, joinPath(path, fmt.Sprintf("%v", key)))
+		}
+		return r
+	case map[string]interface{}:
+		r := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			key := self.substitutes.SubstituteAt(k, joinPath(path, k))
+			if _, dup := r[key]; dup {
+				self.substitutes.fail(joinPath(path, key), "duplicate key after substitution")
+			}
+			r[key] = self.interpolateValue(e, joinPath(path, key))
+		}
+		return r
+	case []interface{}:
+		for i, e := range t {
+			t[i] = self.interpolateValue(e, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+	return v
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
 func readFile(file string) []byte {
 	b, e := ioutil.ReadFile(filepath.FromSlash(file))
 	panicOnError(e)
@@ -139,12 +229,14 @@ func readFile(file string) []byte {
 }
 
 func (self *Descriptors) transformDockerCompose(c *dockerCompose, r *PodDescriptor) {
-	version, err := strconv.ParseFloat(c.Version, 32)
-	if err != nil {
-		panic("Invalid version format: " + c.Version)
-	}
-	if version > 3 {
-		os.Stderr.WriteString("Warn: docker compose version >3 is not supported\n")
+	if c.Version != "" {
+		version, err := strconv.ParseFloat(c.Version, 32)
+		if err != nil {
+			panic("Invalid version format: " + c.Version)
+		}
+		if version > 3 {
+			os.Stderr.WriteString("Warn: docker compose version >3 is not supported\n")
+		}
 	}
 	r.SharedKeys = map[string]string{}
 	for k, v := range c.Services {
@@ -161,8 +253,17 @@ func (self *Descriptors) transformDockerCompose(c *dockerCompose, r *PodDescript
 			}
 		}
 		s.Build = toServiceBuildDescriptor(v.Build, p+".build")
+		s.PullPolicy = v.PullPolicy
+		s.Insecure = BoolVal(v.Insecure)
 		s.Entrypoint = toStringArray(v.Entrypoint, p+".entrypoint")
 		s.Command = toStringArray(v.Command, p+".command")
+		s.WorkingDir = v.WorkingDir
+		s.User = v.User
+		s.Tty = BoolVal(v.Tty)
+		s.StdinOpen = BoolVal(v.StdinOpen)
+		s.Init = BoolVal(v.Init)
+		s.StopSignal = v.StopSignal
+		s.StopGracePeriod = v.StopGracePeriod
 		s.EnvFile = v.EnvFile
 		s.Environment = toStringMap(v.Environment, p+".environment")
 		if v.Hostname != "" {
@@ -171,12 +272,14 @@ func (self *Descriptors) transformDockerCompose(c *dockerCompose, r *PodDescript
 		if v.Domainname != "" {
 			r.Domainname = v.Domainname
 		}
-		if v.StopGracePeriod != "" {
-			r.StopGracePeriod = v.StopGracePeriod
-		}
 		s.Mounts = toVolumeMounts(v.Volumes, p+".volumes")
 		s.Ports = toPorts(v.Ports, p+".ports")
 		s.HealthCheck = toHealthCheckDescriptor(v.HealthCheck, p+".healthcheck")
+		s.OnUnhealthy = toUnhealthyPolicyDescriptor(v.OnUnhealthy, p+".x-on-unhealthy")
+		s.DependsOn = toDependencies(v.DependsOn, p+".depends_on")
+		s.Networks = toServiceNetworks(v.Networks, p+".networks")
+		s.Profiles = v.Profiles
+		s.Pod = v.Pod
 		if httpHost := s.Environment["HTTP_HOST"]; httpHost != "" {
 			httpPort := s.Environment["HTTP_PORT"]
 			if httpPort == "" {
@@ -189,49 +292,166 @@ func (self *Descriptors) transformDockerCompose(c *dockerCompose, r *PodDescript
 	for k := range c.Volumes {
 		r.Volumes[k] = &VolumeDescriptor{self.defaultVolumeBaseDir + "/" + k, "host", "false"}
 	}
+	for k, v := range c.Networks {
+		r.Networks[k] = toNetworkDescriptor(v, "networks."+k)
+	}
 }
 
-func toPorts(p []string, path string) []*PortBindingDescriptor {
+func toNetworkDescriptor(n *dcNetworkDescriptor, path string) *NetworkDescriptor {
+	r := &NetworkDescriptor{}
+	if n == nil {
+		return r
+	}
+	r.Name = n.Name
+	r.Driver = n.Driver
+	if n.DriverOpts != nil {
+		r.DriverOpts = toStringMap(n.DriverOpts, path+".driver_opts")
+	}
+	if n.Ipam != nil {
+		if len(n.Ipam.Config) > 1 {
+			panic(fmt.Sprintf("Only a single ipam config is supported at %s.ipam.config", path))
+		}
+		if len(n.Ipam.Config) == 1 {
+			r.Subnet = n.Ipam.Config[0].Subnet
+			r.Gateway = n.Ipam.Config[0].Gateway
+		}
+	}
+	switch n.External.(type) {
+	case nil:
+	case bool:
+		r.External = BoolVal(strconv.FormatBool(n.External.(bool)))
+	case string:
+		r.External = BoolVal(n.External.(string))
+	case map[interface{}]interface{}:
+		// Deprecated syntax: external.name
+		r.External = "true"
+		if name, ok := n.External.(map[interface{}]interface{})["name"]; ok {
+			r.Name = toString(name, path+".external.name")
+		}
+	default:
+		panic(fmt.Sprintf("bool or map expected at %s.external but was: %s", path, n.External))
+	}
+	return r
+}
+
+func toServiceNetworks(d interface{}, path string) map[string]*ServiceNetworkDescriptor {
+	switch d.(type) {
+	case []interface{}:
+		r := map[string]*ServiceNetworkDescriptor{}
+		for _, name := range toStringArray(d, path) {
+			r[name] = &ServiceNetworkDescriptor{}
+		}
+		return r
+	case map[interface{}]interface{}:
+		r := map[string]*ServiceNetworkDescriptor{}
+		for k, v := range d.(map[interface{}]interface{}) {
+			name := toString(k, path)
+			n := &ServiceNetworkDescriptor{}
+			switch v.(type) {
+			case nil:
+			case map[interface{}]interface{}:
+				if ip, ok := v.(map[interface{}]interface{})["ipv4_address"]; ok {
+					n.IPv4Address = toString(ip, path+"."+name+".ipv4_address")
+				}
+			default:
+				panic(fmt.Sprintf("map expected at %s.%s but was: %s", path, name, v))
+			}
+			r[name] = n
+		}
+		return r
+	case nil:
+		return nil
+	default:
+		panic(fmt.Sprintf("[]string or map expected at %s but was: %s", path, d))
+	}
+}
+
+func toPorts(p []interface{}, path string) []*PortBindingDescriptor {
 	r := []*PortBindingDescriptor{}
-	for _, e := range p {
-		sp := strings.Split(e, "/")
-		if len(sp) > 2 {
-			panic(fmt.Sprintf("Invalid port entry %q at %s", e, path))
-		}
-		prot := "tcp"
-		if len(sp) == 2 {
-			prot = strings.ToLower(sp[1])
-		}
-		s := strings.Split(sp[0], ":")
-		if len(s) > 3 {
-			panic(fmt.Sprintf("Invalid port entry %q at %s", e, path))
-		}
-		var hostIP, hostPortExpr, podPortExpr string
-		switch len(s) {
-		case 1:
-			hostPortExpr = s[0]
-			podPortExpr = hostPortExpr
-		case 2:
-			hostPortExpr = s[0]
-			podPortExpr = s[1]
-		case 3:
-			hostIP = s[0]
-			hostPortExpr = s[1]
-			podPortExpr = s[2]
-		}
-		hostFrom, hostTo := toPortRange(hostPortExpr, path)
-		podFrom, podTo := toPortRange(podPortExpr, path)
-		rangeSize := podTo - podFrom
-		if (hostTo - hostFrom) != rangeSize {
-			panic(fmt.Sprintf("Port %q's range size differs between host and destination at %s", e, path))
-		}
-		for d := 0; d <= rangeSize; d++ {
-			r = append(r, &PortBindingDescriptor{NumberVal(strconv.Itoa(podFrom + d)), NumberVal(strconv.Itoa(hostFrom + d)), hostIP, prot})
+	for i, e := range p {
+		switch e.(type) {
+		case map[interface{}]interface{}:
+			r = append(r, toLongSyntaxPort(e.(map[interface{}]interface{}), fmt.Sprintf("%s[%d]", path, i)))
+		default:
+			r = append(r, toShortSyntaxPorts(toString(e, path), path)...)
 		}
 	}
 	return r
 }
 
+// Converts a port entry using the long syntax. If the target is omitted it is
+// derived from the ports the image exposes
+func toLongSyntaxPort(m map[interface{}]interface{}, path string) *PortBindingDescriptor {
+	r := &PortBindingDescriptor{Protocol: "tcp"}
+	for k, v := range m {
+		ks := toString(k, path)
+		switch ks {
+		case "target":
+			r.Target = NumberVal(strconv.Itoa(toPort(toString(v, path+"."+ks), path+"."+ks)))
+		case "published":
+			r.Published = NumberVal(strconv.Itoa(toPort(toString(v, path+"."+ks), path+"."+ks)))
+		case "host_ip":
+			r.IP = toString(v, path+"."+ks)
+		case "protocol":
+			r.Protocol = strings.ToLower(toString(v, path+"."+ks))
+		case "mode":
+		default:
+			panic(fmt.Sprintf("Unsupported port property %q at %s", ks, path))
+		}
+	}
+	if r.Target == "" && r.Published == "" {
+		panic(fmt.Sprintf("Port requires target or published at %s", path))
+	}
+	return r
+}
+
+func toPort(v, path string) int {
+	from, to := toPortRange(v, path)
+	if from != to {
+		panic(fmt.Sprintf("Single port expected but was %q at %s", v, path))
+	}
+	return from
+}
+
+func toShortSyntaxPorts(e string, path string) []*PortBindingDescriptor {
+	r := []*PortBindingDescriptor{}
+	sp := strings.Split(e, "/")
+	if len(sp) > 2 {
+		panic(fmt.Sprintf("Invalid port entry %q at %s", e, path))
+	}
+	prot := "tcp"
+	if len(sp) == 2 {
+		prot = strings.ToLower(sp[1])
+	}
+	s := strings.Split(sp[0], ":")
+	if len(s) > 3 {
+		panic(fmt.Sprintf("Invalid port entry %q at %s", e, path))
+	}
+	var hostIP, hostPortExpr, podPortExpr string
+	switch len(s) {
+	case 1:
+		hostPortExpr = s[0]
+		podPortExpr = hostPortExpr
+	case 2:
+		hostPortExpr = s[0]
+		podPortExpr = s[1]
+	case 3:
+		hostIP = s[0]
+		hostPortExpr = s[1]
+		podPortExpr = s[2]
+	}
+	hostFrom, hostTo := toPortRange(hostPortExpr, path)
+	podFrom, podTo := toPortRange(podPortExpr, path)
+	rangeSize := podTo - podFrom
+	if (hostTo - hostFrom) != rangeSize {
+		panic(fmt.Sprintf("Port %q's range size differs between host and destination at %s", e, path))
+	}
+	for d := 0; d <= rangeSize; d++ {
+		r = append(r, &PortBindingDescriptor{NumberVal(strconv.Itoa(podFrom + d)), NumberVal(strconv.Itoa(hostFrom + d)), hostIP, prot})
+	}
+	return r
+}
+
 func toPortRange(rangeExpr string, path string) (from, to int) {
 	s := strings.Split(rangeExpr, "-")
 	if len(s) < 3 {
@@ -270,7 +490,7 @@ func toVolumeMounts(dcVols []string, path string) map[string]string {
 func toServiceBuildDescriptor(d interface{}, path string) *ServiceBuildDescriptor {
 	switch d.(type) {
 	case string:
-		return &ServiceBuildDescriptor{d.(string), "", nil}
+		return &ServiceBuildDescriptor{Context: d.(string)}
 	case map[interface{}]interface{}:
 		m := d.(map[interface{}]interface{})
 		r := &ServiceBuildDescriptor{}
@@ -283,13 +503,23 @@ func toServiceBuildDescriptor(d interface{}, path string) *ServiceBuildDescripto
 				r.Dockerfile = toString(v, path+"."+ks)
 			case "args":
 				r.Args = toStringMap(v, path+"."+ks)
+			case "target":
+				r.Target = toString(v, path+"."+ks)
+			case "cache_from":
+				r.CacheFrom = toStringArray(v, path+"."+ks)
+			case "labels":
+				r.Labels = toStringMap(v, path+"."+ks)
+			case "network":
+				r.Network = toString(v, path+"."+ks)
+			case "shm_size":
+				r.ShmSize = toString(v, path+"."+ks)
 			}
 		}
 		return r
 	case nil:
 		return nil
 	default:
-		panic(fmt.Sprintf("string or []string expected at %s but was: %s", path, d))
+		panic(fmt.Sprintf("string or map expected at %s but was: %s", path, d))
 	}
 }
 
@@ -298,21 +528,65 @@ func toHealthCheckDescriptor(c *dcHealthCheckDescriptor, path string) *HealthChe
 		return nil
 	} else {
 		test := toStringArray(c.Test, path)
-		if len(test) == 0 {
-			panic(fmt.Sprintf("%s: undefined health test command", path+".test"))
-		}
 		var cmd []string
-		switch test[0] {
-		case "CMD":
-			cmd = test[1:]
-		case "CMD-SHELL":
-			cmd = append([]string{"/bin/sh", "-c"}, test[1:]...)
-		default:
-			cmd = append([]string{"/bin/sh", "-c"}, strings.Join(test, " "))
+		// A missing test is reported by validate() since it may be merged from another file
+		if len(test) > 0 {
+			switch test[0] {
+			case "CMD":
+				cmd = test[1:]
+			case "CMD-SHELL":
+				cmd = append([]string{"/bin/sh", "-c"}, test[1:]...)
+			default:
+				cmd = append([]string{"/bin/sh", "-c"}, strings.Join(test, " "))
+			}
 		}
 		interval := c.Interval
 		timeout := c.Timeout
-		return &HealthCheckDescriptor{cmd, "", interval, timeout, NumberVal(c.Retries), BoolVal(c.Disable)}
+		return &HealthCheckDescriptor{cmd, "", NumberVal(c.Tcp), NumberVal(c.Grpc), c.GrpcService, interval, timeout, c.StartPeriod, NumberVal(c.Retries), BoolVal(c.Disable)}
+	}
+}
+
+func toUnhealthyPolicyDescriptor(d interface{}, path string) *UnhealthyPolicyDescriptor {
+	switch d.(type) {
+	case string:
+		return &UnhealthyPolicyDescriptor{d.(string), ""}
+	case map[interface{}]interface{}:
+		r := &UnhealthyPolicyDescriptor{}
+		for k, v := range d.(map[interface{}]interface{}) {
+			ks := toString(k, path)
+			switch ks {
+			case "action":
+				r.Action = toString(v, path+"."+ks)
+			case "threshold":
+				r.Threshold = toString(v, path+"."+ks)
+			default:
+				panic(fmt.Sprintf("Unsupported property %q at %s", ks, path))
+			}
+		}
+		return r
+	case nil:
+		return nil
+	default:
+		panic(fmt.Sprintf("string or map expected at %s but was: %s", path, d))
+	}
+}
+
+func toDependencies(d interface{}, path string) []string {
+	switch d.(type) {
+	case []interface{}:
+		return toStringArray(d, path)
+	case map[interface{}]interface{}:
+		// Long syntax: the condition is ignored
+		r := []string{}
+		for k := range d.(map[interface{}]interface{}) {
+			r = append(r, toString(k, path))
+		}
+		sort.Strings(r)
+		return r
+	case nil:
+		return nil
+	default:
+		panic(fmt.Sprintf("[]string or map expected at %s but was: %s", path, d))
 	}
 }
 
@@ -379,34 +653,70 @@ type dockerCompose struct {
 	Version  string
 	Services map[string]*dcServiceDescriptor
 	Volumes  map[string]interface{}
+	Networks map[string]*dcNetworkDescriptor
 }
 
 type dcServiceDescriptor struct {
 	Extends         *dcServiceDescriptorExtension
 	Image           string
 	Build           interface{} // string or map[interface{}]interface{}
+	PullPolicy      string      `yaml:"pull_policy"`
+	Insecure        string      `yaml:"x-insecure"`
 	Hostname        string
 	Domainname      string
-	Entrypoint      interface{}              // string or array
-	Command         interface{}              // string or array
+	Entrypoint      interface{} // string or array
+	Command         interface{} // string or array
+	WorkingDir      string      `yaml:"working_dir"`
+	User            string
+	Tty             string
+	StdinOpen       string `yaml:"stdin_open"`
+	Init            string
+	StopSignal      string                   `yaml:"stop_signal"`
 	EnvFile         []string                 `yaml:"env_file"`
 	Environment     interface{}              // array of VAR=VAL or map
 	HealthCheck     *dcHealthCheckDescriptor `yaml:"healthcheck"`
-	Ports           []string
+	Ports           []interface{}            // array of strings or maps
 	Volumes         []string
-	StopGracePeriod string `yaml:"stop_grace_period"`
+	StopGracePeriod string      `yaml:"stop_grace_period"`
+	OnUnhealthy     interface{} `yaml:"x-on-unhealthy"` // string or map
+	DependsOn       interface{} `yaml:"depends_on"`     // array or map
+	Networks        interface{} // array or map
+	Profiles        []string
+	Pod             string `yaml:"x-pod"`
 	// TODO: Checkout 'secret' dc property
 }
 
+type dcNetworkDescriptor struct {
+	Name       string
+	Driver     string
+	DriverOpts interface{} `yaml:"driver_opts"`
+	Ipam       *dcIpam
+	External   interface{} // bool or map
+}
+
+type dcIpam struct {
+	Config []*dcIpamConfig
+}
+
+type dcIpamConfig struct {
+	Subnet  string
+	Gateway string
+}
+
 type dcServiceDescriptorExtension struct {
 	File    string
 	Service string
 }
 
 type dcHealthCheckDescriptor struct {
-	Test     interface{}
-	Interval string
-	Timeout  string
-	Retries  string
-	Disable  string
+	Test        interface{}
+	Interval    string
+	Timeout     string
+	StartPeriod string `yaml:"start_period"`
+	Retries     string
+	Disable     string
+	// Extensions to check a port instead of running a command within the container
+	Tcp         string `yaml:"x-tcp"`
+	Grpc        string `yaml:"x-grpc"`
+	GrpcService string `yaml:"x-grpc-service"`
 }
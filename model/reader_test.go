@@ -1,9 +1,13 @@
 package model
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/mgoltzsche/rkt-compose/log"
 	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -18,7 +22,7 @@ func TestRead(t *testing.T) {
 			return
 		}
 		expected := strings.Trim(string(expectedBytes), "\n")
-		models := NewDescriptors("./volumes")
+		models := NewDescriptors("./volumes", nil)
 		// TODO: also try parsing json version
 		descr, err := models.Descriptor(dcFile)
 		if err != nil {
@@ -43,7 +47,7 @@ func diff(expected, actual string) string {
 			break
 		}
 	}
-	fmt.Printf(actual)
+	fmt.Print(actual)
 	start := int(math.Max(0, float64(pos-5)))
 	expectedEnd := int(math.Min(float64(len(expectedSegs)), float64(start+11)))
 	actualEnd := int(math.Min(float64(len(actualSegs)), float64(start+11)))
@@ -51,3 +55,324 @@ func diff(expected, actual string) string {
 	aDiff := strings.Join(actualSegs[start:actualEnd], "\n")
 	return fmt.Sprintf("Expected at line %d:\n%s\n\nBut was:\n%s\n", pos, eDiff, aDiff)
 }
+
+func TestSelectServices(t *testing.T) {
+	descr, err := NewDescriptors("./volumes", nil).Descriptor("../test-resources/reference-model.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSelection(t, descr, nil, nil, "extbuild extservice myservice selfbuilt1")
+	assertSelection(t, descr, []string{"debug"}, nil, "extbuild extservice myservice selfbuilt1 selfbuilt2")
+	assertSelection(t, descr, nil, []string{"extservice"}, "extservice myservice")
+	assertSelection(t, descr, nil, []string{"selfbuilt2"}, "selfbuilt2")
+	if _, err = descr.SelectServices(nil, []string{"unknown"}); err == nil {
+		t.Error("selecting an unknown service should fail")
+	}
+}
+
+func assertSelection(t *testing.T, descr *PodDescriptor, profiles, services []string, expected string) {
+	selected, err := descr.SelectServices(profiles, services)
+	if err != nil {
+		t.Errorf("SelectServices(%v, %v) returned error: %s", profiles, services, err)
+		return
+	}
+	if actual := strings.Join(selected, " "); actual != expected {
+		t.Errorf("SelectServices(%v, %v) should return %q but was %q", profiles, services, expected, actual)
+	}
+}
+
+func TestMergedDescriptor(t *testing.T) {
+	// docker-compose.override.yml is merged automatically
+	descr, err := NewDescriptors("./volumes", nil).MergedDescriptor("../test-resources/override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := descr.Services["web"]
+	if descr.Services["mailcatcher"] == nil {
+		t.Error("service defined in override file should be added")
+	}
+	if web.Image != "docker://nginx:alpine" {
+		t.Errorf("image should be kept but was %q", web.Image)
+	}
+	if web.Environment["LOGLEVEL"] != "debug" || web.Environment["DOMAIN"] != "example.org" {
+		t.Errorf("environment should be merged but was %v", web.Environment)
+	}
+	ports := []string{}
+	for _, p := range web.Ports {
+		ports = append(ports, string(p.Published)+":"+string(p.Target))
+	}
+	if actual := strings.Join(ports, " "); actual != "8080:80 443:443 9000:9000" {
+		t.Errorf("ports should be merged by target but were %q", actual)
+	}
+	if web.Mounts["/usr/share/nginx/html"] != "./dev-html" || web.Mounts["/etc/nginx/conf.d"] != "./conf" {
+		t.Errorf("volumes should be merged by target but were %v", web.Mounts)
+	}
+	if strings.Join(web.Command, " ") != "nginx -g \"daemon off;\"" {
+		t.Errorf("command should be kept but was %v", web.Command)
+	}
+
+	// Explicitly provided files replace the override file
+	descr, err = NewDescriptors("./volumes", nil).MergedDescriptor("../test-resources/override/docker-compose.yml", "../test-resources/override/docker-compose.prod.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if descr.Services["mailcatcher"] != nil {
+		t.Error("override file should not be merged when files are provided explicitly")
+	}
+	if cmd := strings.Join(descr.Services["web"].Command, " "); cmd != "nginx -c /etc/nginx/prod.conf" {
+		t.Errorf("command should be replaced but was %q", cmd)
+	}
+
+	// Build and healthcheck are merged field by field, paths are relative to the first file
+	descr, err = NewDescriptors("./volumes", nil).MergedDescriptor("../test-resources/override/docker-compose.yml", "../test-resources/override/ci/docker-compose.ci.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := descr.Services["app"]
+	if b := app.Build; b == nil || b.Context != "./app" || b.Args["VERSION"] != "1" || b.Args["DEBUG"] != "true" {
+		t.Errorf("build should be merged but was %+v", b)
+	}
+	if h := app.HealthCheck; h == nil || strings.Join(h.Command, " ") != "true" || h.Interval != "30s" || h.Retries != "5" {
+		t.Errorf("healthcheck should be merged but was %+v", h)
+	}
+	firstFile, _ := filepath.Abs("../test-resources/override/docker-compose.yml")
+	if descr.File != filepath.ToSlash(firstFile) {
+		t.Errorf("merged descriptor's file should be the first file but was %q", descr.File)
+	}
+	if src := app.Mounts["/var/cache/app"]; src != "./cache" {
+		t.Errorf("override's bind mount should be resolved relative to the first file but was %q", src)
+	}
+	if v := descr.Volumes["data"]; v == nil || v.Source != "./volumes/data" {
+		t.Errorf("named volume redeclared by override should be resolved relative to the first file but was %+v", v)
+	}
+	if src := app.Mounts["/var/lib/app"]; src != "data" {
+		t.Errorf("named volume mount should be kept but was %q", src)
+	}
+	if src := descr.Services["web"].Mounts["/usr/share/nginx/html"]; src != "./html" {
+		t.Errorf("first file's relative path should be kept but was %q", src)
+	}
+
+	// Relative paths are resolved against the project directory if set
+	descriptors := NewDescriptors("./volumes", nil)
+	if err = descriptors.SetProjectDirectory("../test-resources/override/ci"); err != nil {
+		t.Fatal(err)
+	}
+	descr, err = descriptors.MergedDescriptor("../test-resources/override/docker-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	projectDir, _ := filepath.Abs("../test-resources/override/ci")
+	projectDir = filepath.ToSlash(projectDir)
+	if src := descr.Services["web"].Mounts["/usr/share/nginx/html"]; src != projectDir+"/dev-html" {
+		t.Errorf("bind mount should be resolved against the project directory but was %q", src)
+	}
+	if ctx := descr.Services["app"].Build.Context; ctx != projectDir+"/app" {
+		t.Errorf("build context should be resolved against the project directory but was %q", ctx)
+	}
+	if v := descr.Volumes["data"]; v == nil || v.Source != projectDir+"/volumes/data" {
+		t.Errorf("volume should be resolved against the project directory but was %+v", v)
+	}
+	if src := descr.Services["app"].Mounts["/var/lib/app"]; src != "data" {
+		t.Errorf("named volume mount should be kept but was %q", src)
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	env := map[string]string{"PORT": "8080", "HTML_DIR": "/usr/share/nginx/html", "ENV_FILE": "./extended.env", "DOMAIN": "example.org"}
+	descr, err := NewDescriptors("./volumes", NewSubstitutes(env, log.NewNopLogger())).Descriptor("../test-resources/interpolation.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := descr.Services["web"]
+	actual := fmt.Sprintf("%s %s:%s %v %v %v %v", s.Image, s.Ports[0].Published, s.Ports[0].Target, s.Mounts, s.EnvFile, s.Environment, s.Build.Args)
+	expected := "nginx:alpine 8080:80 map[/usr/share/nginx/html:./html] [./extended.env] map[DOMAIN:example.org PRICE:$5] map[extra:value version:1.13]"
+	if actual != expected {
+		t.Errorf("expected %q but was %q", expected, actual)
+	}
+
+	// Missing required variables
+	_, err = NewDescriptors("./volumes", NewSubstitutes(map[string]string{}, log.NewNopLogger())).Descriptor("../test-resources/interpolation.yml")
+	if err == nil || !strings.Contains(err.Error(), "services.web.environment[0]: DOMAIN: domain must be set") {
+		t.Errorf("missing required variable should result in error with location but was: %v", err)
+	}
+
+	// Keys that collide after substitution
+	env["ARG_NAME"] = "version"
+	_, err = NewDescriptors("./volumes", NewSubstitutes(env, log.NewNopLogger())).Descriptor("../test-resources/interpolation.yml")
+	if err == nil || !strings.Contains(err.Error(), "services.web.build.args.version: duplicate key") {
+		t.Errorf("keys colliding after substitution should result in error but was: %v", err)
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	env := map[string]string{}
+	if err := ReadEnvFile("../test-resources/dotenv.env", env, log.NewNopLogger()); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"PLAIN":            "value",
+		"EXPORTED":         "exported value",
+		"SPACED":           "spaced value",
+		"INLINE":           "value",
+		"HASH":             "value#nocomment",
+		"SINGLE":           "literal $PLAIN \\n",
+		"DOUBLE":           "escaped \"quotes\"\tand value and $PLAIN",
+		"MULTILINE":        "line1\nline2",
+		"MULTILINE_SINGLE": "line1\nline2",
+		"REF":              "value-default",
+		"EMPTY":            "",
+	}
+	for k, v := range expected {
+		if actual, ok := env[k]; !ok || actual != v {
+			t.Errorf("%s: expected %q but was %q", k, v, actual)
+		}
+	}
+	if len(env) != len(expected) {
+		t.Errorf("expected %d entries but was %d: %v", len(expected), len(env), env)
+	}
+	f, err := ioutil.TempFile("", "rkt-compose-env-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("VAR=$RKT_COMPOSE_TEST_UNSET_VAR\n")
+	f.Close()
+	var warnings bytes.Buffer
+	descriptors := NewDescriptors("./volumes", NewSubstitutes(map[string]string{}, log.NewStdLogger(&warnings)))
+	if err = descriptors.ReadEnvFile(f.Name(), map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(warnings.String(), "RKT_COMPOSE_TEST_UNSET_VAR") {
+		t.Errorf("unset variable within env file should be reported to the descriptors' logger")
+	}
+}
+
+func TestBuildConfigHash(t *testing.T) {
+	ctxDir, err := ioutil.TempDir("", "rkt-compose-build-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(ctxDir)
+	writeTestFile(t, ctxDir, "Dockerfile", "FROM alpine:3.6\nCOPY app /app\n")
+	writeTestFile(t, ctxDir, "app", "v1")
+	writeTestFile(t, ctxDir, ".dockerignore", "*.log\n")
+	b := &BuildConfig{Dockerfile: filepath.Join(ctxDir, "Dockerfile"), Context: ctxDir, Args: map[string]string{"a": "1"}}
+	hash := assertBuildHash(t, b, "")
+	hash = assertBuildHash(t, b, hash)
+	writeTestFile(t, ctxDir, "debug.log", "ignored")
+	hash = assertBuildHash(t, b, hash)
+	writeTestFile(t, ctxDir, "app", "v2")
+	hash = assertBuildHash(t, b, "!"+hash)
+	b.Args["a"] = "2"
+	hash = assertBuildHash(t, b, "!"+hash)
+	b.Target = "prod"
+	assertBuildHash(t, b, "!"+hash)
+}
+
+func TestToPorts(t *testing.T) {
+	ports := toPorts([]interface{}{
+		"8080:80",
+		"127.0.0.1:53:53/udp",
+		map[interface{}]interface{}{"target": 80, "published": "8081", "host_ip": "127.0.0.1"},
+		map[interface{}]interface{}{"published": 8082, "protocol": "UDP", "mode": "host"},
+	}, "ports")
+	actual := []string{}
+	for _, p := range ports {
+		actual = append(actual, fmt.Sprintf("%s:%s:%s/%s", p.IP, p.Published, p.Target, p.Protocol))
+	}
+	expected := ":8080:80/tcp 127.0.0.1:53:53/udp 127.0.0.1:8081:80/tcp :8082:/udp"
+	if a := strings.Join(actual, " "); a != expected {
+		t.Errorf("expected ports %s but was %s", expected, a)
+	}
+	for _, invalid := range []interface{}{"8080:", map[interface{}]interface{}{"host_ip": "127.0.0.1"}, map[interface{}]interface{}{"published": "8080-8081"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("toPorts() should reject %v", invalid)
+				}
+			}()
+			toPorts([]interface{}{invalid}, "ports")
+		}()
+	}
+}
+
+func TestToPullPolicy(t *testing.T) {
+	for _, c := range []struct {
+		policy   string
+		expected PullPolicy
+	}{
+		{"", ""},
+		{"always", PULL_UPDATE},
+		{"missing", PULL_NEW},
+		{"if_not_present", PULL_NEW},
+		{"never", PULL_NEVER},
+		{"build", PULL_NEVER},
+	} {
+		p, err := ToPullPolicy(c.policy)
+		if err != nil {
+			t.Errorf("ToPullPolicy(%q) returned error: %s", c.policy, err)
+		} else if p != c.expected {
+			t.Errorf("ToPullPolicy(%q) should return %q but returned %q", c.policy, c.expected, p)
+		}
+	}
+	if _, err := ToPullPolicy("sometimes"); err == nil {
+		t.Errorf("ToPullPolicy(\"sometimes\") should return error")
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	digest := "sha256:0b03b91b7e1b5b5b6e9bd0b1e2b6e7cbd4c6a2fc5b2a4d1b0cc2d3d9b6e7f801"
+	for name, expected := range map[string]string{
+		"docker://alpine:3.6":                     "",
+		"docker://alpine@" + digest:               digest,
+		"docker://registry:5000/alpine@" + digest: digest,
+		"example.org/app:1.0":                     "",
+	} {
+		if d := ImageDigest(name); d != expected {
+			t.Errorf("ImageDigest(%q) should return %q but returned %q", name, expected, d)
+		}
+	}
+	if err := CheckImageVerifiable("docker://alpine:3.6", false); err == nil {
+		t.Errorf("CheckImageVerifiable() should reject docker image without digest")
+	}
+	for _, c := range []struct {
+		name     string
+		insecure bool
+	}{{"docker://alpine:3.6", true}, {"docker://alpine@" + digest, false}, {"example.org/app:1.0", false}} {
+		if err := CheckImageVerifiable(c.name, c.insecure); err != nil {
+			t.Errorf("CheckImageVerifiable(%q, %v) returned error: %s", c.name, c.insecure, err)
+		}
+	}
+	annotations := []*aciAnnotation{{"appc.io/docker/manifesthash", digest}}
+	if err := verifyDigest("docker://alpine@"+digest, digest, annotations); err != nil {
+		t.Errorf("verifyDigest() returned error for matching digest: %s", err)
+	}
+	if err := verifyDigest("docker://alpine@sha256:other", "sha256:other", annotations); err == nil {
+		t.Errorf("verifyDigest() should return error for different digest")
+	}
+	if err := verifyDigest("docker://alpine@"+digest, digest, nil); err == nil {
+		t.Errorf("verifyDigest() should return error if annotation is missing")
+	}
+}
+
+// Asserts the hash equals expected or, if prefixed with !, differs from it
+func assertBuildHash(t *testing.T, b *BuildConfig, expected string) string {
+	hash, err := b.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(expected, "!") {
+		if hash == expected[1:] {
+			t.Errorf("build hash should change")
+		}
+	} else if expected != "" && hash != expected {
+		t.Errorf("build hash should not change")
+	}
+	return hash
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
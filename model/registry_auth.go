@@ -0,0 +1,135 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Docker registry credentials by registry host
+type RegistryAuth map[string]*RegistryCredentials
+
+type RegistryCredentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Docker config.json subset
+type dockerConfig struct {
+	Auths map[string]*dockerConfigAuth `json:"auths"`
+}
+
+type dockerConfigAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// rkt's auth.d docker auth config
+type rktDockerAuth struct {
+	RktKind     string               `json:"rktKind"`
+	RktVersion  string               `json:"rktVersion"`
+	Registries  []string             `json:"registries"`
+	Credentials *RegistryCredentials `json:"credentials"`
+}
+
+// Returns the docker config.json file within $DOCKER_CONFIG or ~/.docker
+func DockerConfigFile() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// Reads the registry credentials from docker config.json files.
+// Credentials within later files override those of previous files.
+func ReadRegistryAuth(files []string) (RegistryAuth, error) {
+	r := RegistryAuth{}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read registry auth file: %s", err)
+		}
+		c := dockerConfig{}
+		if err = json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("Cannot unmarshal registry auth file %q: %s", file, err)
+		}
+		for registry, a := range c.Auths {
+			cred, err := a.credentials()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid auth of registry %q in %q: %s", registry, file, err)
+			}
+			if cred != nil {
+				r[registryHost(registry)] = cred
+			}
+		}
+	}
+	return r, nil
+}
+
+// Returns the credentials or nil if the entry has none (e.g. when a credential helper is used)
+func (a *dockerConfigAuth) credentials() (*RegistryCredentials, error) {
+	if a.Auth != "" {
+		b, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return nil, err
+		}
+		s := strings.SplitN(string(b), ":", 2)
+		if len(s) != 2 {
+			return nil, fmt.Errorf("auth is not of the form user:password")
+		}
+		return &RegistryCredentials{s[0], s[1]}, nil
+	}
+	if a.Username != "" {
+		return &RegistryCredentials{a.Username, a.Password}, nil
+	}
+	return nil, nil
+}
+
+// Writes the credentials as rkt docker auth configs into the auth.d directory
+func (a RegistryAuth) writeRktAuthConfig(authDir string) error {
+	if err := os.MkdirAll(authDir, 0700); err != nil {
+		return err
+	}
+	registries := make([]string, 0, len(a))
+	for k := range a {
+		registries = append(registries, k)
+	}
+	sort.Strings(registries)
+	for i, registry := range registries {
+		hosts := []string{registry}
+		if registry == "docker.io" {
+			// rkt fetches docker hub images from registry-1.docker.io
+			hosts = append(hosts, "registry-1.docker.io", "index.docker.io")
+		}
+		b, err := json.Marshal(&rktDockerAuth{"dockerAuth", "v1", hosts, a[registry]})
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(authDir, fmt.Sprintf("%02d-%s.json", i, toId(registry)))
+		if err = ioutil.WriteFile(file, b, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Normalizes a docker config registry key to the registry's host
+func registryHost(registry string) string {
+	h := registry
+	if pos := strings.Index(h, "://"); pos != -1 {
+		h = h[pos+3:]
+	}
+	h = strings.SplitN(h, "/", 2)[0]
+	switch h {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return h
+}
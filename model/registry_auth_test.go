@@ -0,0 +1,51 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRegistryAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-compose-auth-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "config.json", `{"auths": {
+		"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNzOndvcmQ="},
+		"registry.example.org": {"username": "old", "password": "old"},
+		"helper.example.org": {}
+	}}`)
+	writeTestFile(t, dir, "override.json", `{"auths": {"registry.example.org:5000": {"username": "ci", "password": "secret"}, "https://registry.example.org/v2/": {"username": "ci", "password": "secret"}}}`)
+	auth, err := ReadRegistryAuth([]string{filepath.Join(dir, "config.json"), filepath.Join(dir, "override.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]RegistryCredentials{
+		"docker.io":                 {"user", "pass:word"},
+		"registry.example.org":      {"ci", "secret"},
+		"registry.example.org:5000": {"ci", "secret"},
+	}
+	if len(auth) != len(expected) {
+		t.Errorf("expected %d registries but was %d: %v", len(expected), len(auth), auth)
+	}
+	for k, v := range expected {
+		if a := auth[k]; a == nil || *a != v {
+			t.Errorf("expected %s credentials %v but was %v", k, v, a)
+		}
+	}
+	authDir := filepath.Join(dir, "auth.d")
+	if err = auth.writeRktAuthConfig(authDir); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(authDir, "00-docker-io.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedJSON := `{"rktKind":"dockerAuth","rktVersion":"v1","registries":["docker.io","registry-1.docker.io","index.docker.io"],"credentials":{"user":"user","password":"pass:word"}}`
+	if string(b) != expectedJSON {
+		t.Errorf("unexpected rkt auth config: %s", string(b))
+	}
+}
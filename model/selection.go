@@ -0,0 +1,71 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Returns the names of the services that should be run.
+// A service is enabled when it has no profile, one of its profiles is active
+// or it is named explicitly. When services are named only those and their
+// dependencies are returned.
+func (d *PodDescriptor) SelectServices(profiles, services []string) ([]string, error) {
+	selected := map[string]bool{}
+	if len(services) == 0 {
+		active := map[string]bool{}
+		for _, p := range profiles {
+			active[p] = true
+		}
+		for k, s := range d.Services {
+			if isProfileEnabled(s, active) {
+				selected[k] = true
+			}
+		}
+	} else {
+		for _, k := range services {
+			if d.Services[k] == nil {
+				return nil, fmt.Errorf("Service %q is not defined in %s", k, d.File)
+			}
+			selected[k] = true
+		}
+	}
+	// Add dependencies
+	pending := make([]string, 0, len(selected))
+	for k := range selected {
+		pending = append(pending, k)
+	}
+	for len(pending) > 0 {
+		k := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range d.Services[k].DependsOn {
+			if d.Services[dep] == nil {
+				return nil, fmt.Errorf("Service %q depends on undefined service %q", k, dep)
+			}
+			if !selected[dep] {
+				selected[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No service enabled in %s. Activate a profile or name a service", d.File)
+	}
+	r := make([]string, 0, len(selected))
+	for k := range selected {
+		r = append(r, k)
+	}
+	sort.Strings(r)
+	return r, nil
+}
+
+func isProfileEnabled(s *ServiceDescriptor, active map[string]bool) bool {
+	if len(s.Profiles) == 0 {
+		return true
+	}
+	for _, p := range s.Profiles {
+		if active[p] {
+			return true
+		}
+	}
+	return false
+}
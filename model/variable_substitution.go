@@ -0,0 +1,184 @@
+package model
+
+import (
+	"fmt"
+	"github.com/mgoltzsche/rkt-compose/log"
+	"sort"
+	"strings"
+)
+
+// Interpolates variables using the docker compose grammar:
+//
+//	$$                escaped $
+//	$VAR, ${VAR}      value or blank string if unset
+//	${VAR-default}    default if VAR is unset
+//	${VAR:-default}   default if VAR is unset or empty
+//	${VAR?err}        fails with err if VAR is unset
+//	${VAR:?err}       fails with err if VAR is unset or empty
+//	${VAR+alt}        alt if VAR is set, otherwise blank string
+//	${VAR:+alt}       alt if VAR is set and not empty, otherwise blank string
+//
+// default, err and alt may contain expressions themselves.
+// Errors are collected and returned by Err() at once.
+type Substitutes struct {
+	substitutes map[string]string
+	warn        log.Logger
+	errors      []string
+}
+
+func NewSubstitutes(env map[string]string, warn log.Logger) *Substitutes {
+	return &Substitutes{env, warn, nil}
+}
+
+func (self *Substitutes) Substitute(v string) string {
+	return self.SubstituteAt(v, "")
+}
+
+// Substitutes the variables within v. The location is used in error messages.
+func (self *Substitutes) SubstituteAt(v, location string) string {
+	if location == "" {
+		location = fmt.Sprintf("%q", v)
+	}
+	return self.interpolate(v, location)
+}
+
+// Returns an error listing all failed substitutions since the last call
+func (self *Substitutes) Err() error {
+	if len(self.errors) == 0 {
+		return nil
+	}
+	sort.Strings(self.errors)
+	err := fmt.Errorf("Invalid variable substitution:\n  %s", strings.Join(self.errors, "\n  "))
+	self.errors = nil
+	return err
+}
+
+func (self *Substitutes) fail(location, msg string) {
+	self.errors = append(self.errors, location+": "+msg)
+}
+
+func (self *Substitutes) interpolate(v, location string) string {
+	if strings.IndexByte(v, '$') == -1 {
+		return v
+	}
+	r := make([]byte, 0, len(v))
+	for i := 0; i < len(v); {
+		c := v[i]
+		if c != '$' || i+1 == len(v) {
+			r = append(r, c)
+			i++
+			continue
+		}
+		switch n := v[i+1]; {
+		case n == '$':
+			r = append(r, '$')
+			i += 2
+		case n == '{':
+			end := closingBrace(v, i+2)
+			if end == -1 {
+				self.fail(location, fmt.Sprintf("unterminated expression %q", v[i:]))
+				return string(append(r, v[i:]...))
+			}
+			r = append(r, self.expand(v[i+2:end], location)...)
+			i = end + 1
+		case isVarNameChar(n):
+			j := i + 1
+			for j < len(v) && isVarNameChar(v[j]) {
+				j++
+			}
+			r = append(r, self.lookup(v[i+1:j])...)
+			i = j
+		default:
+			r = append(r, c)
+			i++
+		}
+	}
+	return string(r)
+}
+
+// Evaluates the expression within ${...}
+func (self *Substitutes) expand(expr, location string) string {
+	i := 0
+	for i < len(expr) && isVarNameChar(expr[i]) {
+		i++
+	}
+	name := expr[:i]
+	if name == "" {
+		self.fail(location, fmt.Sprintf("invalid expression \"${%s}\"", expr))
+		return ""
+	}
+	op := expr[i:]
+	if op == "" {
+		return self.lookup(name)
+	}
+	value, set := self.substitutes[name]
+	nonEmpty := op[0] == ':'
+	if nonEmpty {
+		op = op[1:]
+		set = set && value != ""
+	}
+	if op == "" {
+		self.fail(location, fmt.Sprintf("invalid expression \"${%s}\"", expr))
+		return ""
+	}
+	arg := op[1:]
+	switch op[0] {
+	case '-':
+		if set {
+			return value
+		}
+		return self.interpolate(arg, location)
+	case '?':
+		if set {
+			return value
+		}
+		msg := self.interpolate(arg, location)
+		if msg == "" {
+			msg = "required variable is missing a value"
+		}
+		self.fail(location, name+": "+msg)
+		return ""
+	case '+':
+		if set {
+			return self.interpolate(arg, location)
+		}
+		return ""
+	default:
+		self.fail(location, fmt.Sprintf("invalid expression \"${%s}\"", expr))
+		return ""
+	}
+}
+
+func (self *Substitutes) lookup(name string) string {
+	if s, ok := self.substitutes[name]; ok {
+		return s
+	}
+	self.warn.Printf("Warn: %s env var is not set. Defaulting to blank string.", name)
+	return ""
+}
+
+// Returns the position of the } closing the expression starting at pos or -1
+func closingBrace(v string, pos int) int {
+	depth := 0
+	for i := pos; i < len(v); i++ {
+		switch v[i] {
+		case '$':
+			if i+1 < len(v) && v[i+1] == '{' {
+				depth++
+				i++
+			} else if i+1 < len(v) && v[i+1] == '$' {
+				i++
+			}
+		case '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func isVarNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"github.com/mgoltzsche/rkt-compose/log"
+	"strings"
+	"testing"
+)
+
+var testenv = map[string]string{
+	"VAR1":  "dyn1",
+	"VAR2":  "dyn2",
+	"EMPTY": "",
+}
+
+func TestSubstitution(t *testing.T) {
+	assertSubstitution(t, "static-dyn1", "static-$VAR1")
+	assertSubstitution(t, "static-dyn1-XY", "static-$VAR1-XY")
+	assertSubstitution(t, "static-dyn1-dyn2", "static-$VAR1-$VAR2")
+	assertSubstitution(t, "static-dyn1-dyn2-", "static-$VAR1-$VAR2-$VAR3")
+	assertSubstitution(t, "static-dyn1", "static-${VAR1}")
+	assertSubstitution(t, "static-dyn1-XY", "static-${VAR1}-XY")
+	assertSubstitution(t, "static-dyn1-dyn2", "static-${VAR1}-${VAR2}")
+	assertSubstitution(t, "static-dyn1-dyn2-", "static-${VAR1}-${VAR2}-${VAR3}")
+	assertSubstitution(t, "static-dyn1-dyn2-defaultval", "static-${VAR1}-${VAR2}-${VAR3-defaultval}")
+	assertSubstitution(t, "static-dyn1-dyn2-defaultval", "static-${VAR1}-${VAR2}-${VAR3:-defaultval}")
+	assertSubstitution(t, "$VAR1 costs $5", "$$VAR1 costs $$5")
+	assertSubstitution(t, "", "${EMPTY-defaultval}")
+	assertSubstitution(t, "defaultval", "${EMPTY:-defaultval}")
+	assertSubstitution(t, "alt", "${VAR1+alt}")
+	assertSubstitution(t, "alt", "${EMPTY+alt}")
+	assertSubstitution(t, "", "${EMPTY:+alt}")
+	assertSubstitution(t, "", "${VAR3+alt}")
+	assertSubstitution(t, "dyn1", "${VAR1?required}")
+	assertSubstitution(t, "x-dyn2-y", "${VAR3:-x-${VAR4:-${VAR2}}-y}")
+	assertSubstitution(t, "a-dyn1}", "a-${VAR1}}")
+}
+
+func TestSubstitutionErrors(t *testing.T) {
+	testee := NewSubstitutes(testenv, log.NewNopLogger())
+	testee.SubstituteAt("${VAR3?must be set}", "services.a.image")
+	testee.SubstituteAt("${EMPTY:?}", "services.b.image")
+	testee.SubstituteAt("${EMPTY?unused}-${VAR1:-${VAR3?unused}}", "services.c.image")
+	testee.SubstituteAt("${VAR1", "services.d.image")
+	err := testee.Err()
+	if err == nil {
+		t.Fatal("unresolved required variables should result in an error")
+	}
+	for _, expected := range []string{"services.a.image: VAR3: must be set", "services.b.image: EMPTY: required variable", "services.d.image: unterminated"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("error should contain %q but was: %s", expected, err)
+		}
+	}
+	if strings.Contains(err.Error(), "services.c.image") {
+		t.Errorf("set variable and unevaluated default should not result in an error: %s", err)
+	}
+	if testee.Err() != nil {
+		t.Error("Err() should reset the errors")
+	}
+}
+
+func assertSubstitution(t *testing.T, expected string, input string) {
+	testee := NewSubstitutes(testenv, log.NewNopLogger())
+	actual := testee.Substitute(input)
+	if actual != expected {
+		t.Errorf("%q should be replaced with %q but was %q", input, expected, actual)
+	}
+	if err := testee.Err(); err != nil {
+		t.Errorf("%q substitution failed: %s", input, err)
+	}
+}